@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/i18n"
+	"interview_assignment.mohamednaas.net/internal/password"
+	"interview_assignment.mohamednaas.net/internal/validator"
+)
+
+// newFlagSet returns a flag set for a command which returns parse errors instead of
+// exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("admin "+name, flag.ContinueOnError)
+}
+
+// readPassword returns the password given with -password, or the first line of stdin if
+// -password-stdin was given. Reading from stdin keeps the password out of the shell
+// history and process list.
+func (app *cli) readPassword(password string, fromStdin bool) (string, error) {
+	if password != "" && fromStdin {
+		return "", errors.New("only one of -password and -password-stdin may be given")
+	}
+	if !fromStdin {
+		return password, nil
+	}
+	line, err := bufio.NewReader(app.stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// lookupUser fetches a user by email, turning a missing record into a readable error.
+func (app *cli) lookupUser(ctx context.Context, email string) (data.User, error) {
+	if email == "" {
+		return data.User{}, errors.New("-email must be provided")
+	}
+	user, err := app.models.Users.UserGet(ctx, email)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return user, fmt.Errorf("no user with email %q", email)
+	}
+	return user, err
+}
+
+// validationError joins the messages collected by a validator into a single error.
+func validationError(v *validator.Validator) error {
+	var msgs []string
+	for field, msg := range v.Errors {
+		msgs = append(msgs, field+": "+i18n.Message(i18n.English, msg.Key, msg.Args...))
+	}
+	sort.Strings(msgs)
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func (app *cli) createUser(ctx context.Context, args []string) error {
+	fs := newFlagSet("create-user")
+	name := fs.String("name", "", "Name of the user")
+	email := fs.String("email", "", "Email address of the user")
+	password := fs.String("password", "", "Password of the user")
+	passwordStdin := fs.Bool("password-stdin", false, "Read the password from stdin")
+	admin := fs.Bool("admin", false, "Grant the user admin rights")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pass, err := app.readPassword(*password, *passwordStdin)
+	if err != nil {
+		return err
+	}
+	user := data.User{
+		Name:     *name,
+		Email:    *email,
+		Password: pass,
+	}
+
+	// Apply the same rules as the registration endpoint.
+	v := validator.New()
+	if data.ValidateUserRegisteration(v, &user, app.passwordPolicy); !v.Valid() {
+		return validationError(v)
+	}
+
+	// Create the user and grant admin rights together, so that a failed grant doesn't
+	// leave an ordinary user behind.
+	err = app.models.Transaction(ctx, func(tx data.Models) error {
+		user.ID, err = tx.Users.UserCreate(ctx, user)
+		if err != nil {
+			return err
+		}
+		if *admin {
+			return tx.Users.AdminGrant(ctx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			return fmt.Errorf("a user with email %q already exists", user.Email)
+		}
+		return err
+	}
+
+	result := struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Admin bool   `json:"admin"`
+	}{user.ID, user.Name, user.Email, *admin}
+	return app.output(result, fmt.Sprintf("created user %d (%s), admin: %t", user.ID, user.Email, *admin))
+}
+
+func (app *cli) listUsers(ctx context.Context, args []string) error {
+	fs := newFlagSet("list-users")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	users, err := app.models.Users.UsersGet(ctx)
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Admin bool   `json:"admin"`
+	}
+	rows := []row{}
+	var text strings.Builder
+	fmt.Fprintf(&text, "%-6s %-6s %-30s %s\n", "ID", "ADMIN", "EMAIL", "NAME")
+	for _, u := range users {
+		isAdmin, err := app.models.Users.IsAdmin(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		r := row{u.ID, u.Name, u.Email, isAdmin}
+		rows = append(rows, r)
+		fmt.Fprintf(&text, "%-6d %-6t %-30s %s\n", r.ID, r.Admin, r.Email, r.Name)
+	}
+	return app.output(rows, text.String())
+}
+
+func (app *cli) setAdmin(ctx context.Context, command string, args []string, admin bool) error {
+	fs := newFlagSet(command)
+	email := fs.String("email", "", "Email address of the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := app.lookupUser(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if admin {
+		err = app.models.Users.AdminGrant(ctx, user.ID)
+	} else {
+		err = app.models.Users.AdminRevoke(ctx, user.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		ID    int    `json:"id"`
+		Email string `json:"email"`
+		Admin bool   `json:"admin"`
+	}{user.ID, user.Email, admin}
+	return app.output(result, fmt.Sprintf("user %d (%s), admin: %t", user.ID, user.Email, admin))
+}
+
+func (app *cli) resetPassword(ctx context.Context, args []string) error {
+	fs := newFlagSet("reset-password")
+	email := fs.String("email", "", "Email address of the user")
+	password := fs.String("password", "", "New password")
+	passwordStdin := fs.Bool("password-stdin", false, "Read the new password from stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := app.lookupUser(ctx, *email)
+	if err != nil {
+		return err
+	}
+	pass, err := app.readPassword(*password, *passwordStdin)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, app.passwordPolicy, pass, &user)
+	if !v.Valid() {
+		return validationError(v)
+	}
+
+	if err := app.models.Users.UserUpdatePassword(ctx, user.Email, pass); err != nil {
+		return err
+	}
+
+	result := struct {
+		ID    int    `json:"id"`
+		Email string `json:"email"`
+	}{user.ID, user.Email}
+	return app.output(result, fmt.Sprintf("password reset for user %d (%s)", user.ID, user.Email))
+}
+
+func (app *cli) unlock(ctx context.Context, args []string) error {
+	fs := newFlagSet("unlock")
+	email := fs.String("email", "", "Email address whose failed logins to forget")
+	ip := fs.String("ip", "", "Client IP address whose failed logins to forget")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*email == "") == (*ip == "") {
+		return errors.New("exactly one of -email and -ip must be provided")
+	}
+
+	key := data.AccountLoginKey(*email)
+	if *ip != "" {
+		key = data.ClientLoginKey(*ip)
+	}
+	// Failed logins are counted for any email address, so there needn't be a user.
+	err := app.models.LoginFailures.LoginFailureDelete(ctx, key)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+
+	result := struct {
+		Key      string `json:"key"`
+		Unlocked bool   `json:"unlocked"`
+	}{key, err == nil}
+	if err != nil {
+		return app.output(result, fmt.Sprintf("%s has no failed logins", key))
+	}
+	return app.output(result, fmt.Sprintf("unlocked %s", key))
+}
+
+func (app *cli) setAssignment(ctx context.Context, command string, args []string, assign bool) error {
+	fs := newFlagSet(command)
+	email := fs.String("email", "", "Email address of the user")
+	categoryID := fs.Int("category", 0, "ID of the category")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := app.lookupUser(ctx, *email)
+	if err != nil {
+		return err
+	}
+	category, err := app.models.Categories.CategoryGet(ctx, *categoryID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("no category with id %d", *categoryID)
+		}
+		return err
+	}
+
+	message := "unassigned category %d (%s) from user %d (%s)"
+	if assign {
+		message = "assigned category %d (%s) to user %d (%s)"
+		err = app.models.UserCategories.InsertUserCategories(ctx, user.ID, category.ID, 0)
+		if errors.Is(err, data.ErrCategoryAlreadyAssigned) {
+			return fmt.Errorf("category %d is already assigned to a user", category.ID)
+		}
+	} else {
+		err = app.models.UserCategories.DeleteUserCategories(ctx, user.ID, category.ID)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fmt.Errorf("category %d is not assigned to %s", category.ID, user.Email)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		UserID     int  `json:"user_id"`
+		CategoryID int  `json:"category_id"`
+		Assigned   bool `json:"assigned"`
+	}{user.ID, category.ID, assign}
+	return app.output(result, fmt.Sprintf(message, category.ID, category.Name, user.ID, user.Email))
+}
+
+func (app *cli) listCategories(ctx context.Context, args []string) error {
+	fs := newFlagSet("list-categories")
+	email := fs.String("email", "", "Only list the categories assigned to this user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var (
+		categories []*data.Category
+		err        error
+	)
+	if *email != "" {
+		user, err := app.lookupUser(ctx, *email)
+		if err != nil {
+			return err
+		}
+		categories, err = app.models.UserCategories.UserCategoriesGet(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		categories, err = app.models.Categories.CategoriesGet(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%-6s %s\n", "ID", "NAME")
+	for _, c := range categories {
+		fmt.Fprintf(&text, "%-6d %s\n", c.ID, c.Name)
+	}
+	return app.output(categories, text.String())
+}
+
+// hashBenchmark measures password hashing on this host and prints the settings for the
+// API's password section which take about -target per hash. It doesn't use the database.
+func (app *cli) hashBenchmark(args []string) error {
+	fs := newFlagSet("hash-benchmark")
+	algorithm := fs.String("algorithm", password.Argon2id, "Algorithm to tune (argon2id|bcrypt)")
+	target := fs.Duration("target", 500*time.Millisecond, "Time a single hash should take")
+	maxMemory := fs.Uint("max-memory", 64*1024, "Most memory argon2id may use per hash, in KiB")
+	parallelism := fs.Uint("parallelism", 1, "Number of argon2id threads")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *target <= 0 {
+		return errors.New("-target must be greater than zero")
+	}
+	if *maxMemory > math.MaxUint32 || *parallelism > math.MaxUint8 {
+		return errors.New("-max-memory or -parallelism is too large")
+	}
+
+	h, elapsed, err := password.Benchmark(*algorithm, *target, uint32(*maxMemory), uint8(*parallelism))
+	if err != nil {
+		return err
+	}
+
+	// The settings are printed in the form of the API's config file.
+	settings := map[string]any{"hash-algorithm": h.Algorithm}
+	if h.Algorithm == password.Bcrypt {
+		settings["bcrypt-cost"] = h.BcryptCost
+	} else {
+		settings["argon2-memory"] = h.Argon2.Memory
+		settings["argon2-iterations"] = h.Argon2.Iterations
+		settings["argon2-parallelism"] = h.Argon2.Parallelism
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "# a hash takes %s on this host\npassword:\n", elapsed.Round(time.Millisecond))
+	for _, name := range names {
+		fmt.Fprintf(&text, "  %s: %v\n", name, settings[name])
+	}
+	result := struct {
+		Settings   map[string]any `json:"settings"`
+		DurationMS int64          `json:"duration_ms"`
+	}{settings, elapsed.Milliseconds()}
+	return app.output(result, text.String())
+}
@@ -0,0 +1,176 @@
+// Command admin manages users, admins and category assignments directly in the
+// database, using the same models as the API. It's mainly used to create the first
+// admin, and every command can be scripted with flags and -json output.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/password"
+	_ "modernc.org/sqlite"
+)
+
+const usage = `usage: admin [flags] <command> [command flags]
+
+commands:
+  create-user      create a user (-name, -email, -password or -password-stdin, -admin)
+  list-users       list every user and whether they are an admin
+  promote          grant admin rights to a user (-email)
+  demote           revoke admin rights from a user (-email)
+  reset-password   set a new password for a user (-email, -password or -password-stdin)
+  unlock           forget the failed logins for an account or client (-email or -ip)
+  assign           assign a category to a user (-email, -category)
+  unassign         remove a category from a user (-email, -category)
+  list-categories  list all categories, or the categories assigned to a user (-email)
+  hash-benchmark   recommend password hashing settings for this host (-algorithm, -target,
+                   -max-memory, -parallelism); doesn't need a database
+
+flags:`
+
+// The cli struct holds the dependencies shared by every command.
+type cli struct {
+	models data.Models
+	// passwordPolicy is the policy new passwords are checked against, the API's
+	// default one.
+	passwordPolicy password.Policy
+	json           bool
+	stdin          io.Reader
+	stdout         io.Writer
+}
+
+func main() {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	dsn := fs.String("db-dsn", defaultDSN(), "Database DSN, postgres://... or sqlite:path (defaults to SAINPR_DB_DSN)")
+	jsonOutput := fs.Bool("json", false, "Write results as JSON")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time a command may take")
+
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// hash-benchmark only measures this host, so it runs without a database.
+	if fs.Arg(0) == "hash-benchmark" {
+		app := &cli{json: *jsonOutput, stdout: os.Stdout}
+		if err := app.hashBenchmark(fs.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *dsn == "" {
+		fmt.Fprintln(os.Stderr, "admin: -db-dsn must be provided")
+		os.Exit(2)
+	}
+
+	source, err := data.ParseDSN(*dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin: -db-dsn: %v\n", err)
+		os.Exit(2)
+	}
+	db, err := sql.Open(source.Driver, source.DSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	app := &cli{
+		// Commands are limited by -timeout as a whole rather than per operation. New
+		// passwords are hashed with the API's default settings, and rehashed with the
+		// configured ones when their users log in.
+		models:         data.NewModels(db, data.Timeouts{}, password.DefaultHasher()),
+		passwordPolicy: password.DefaultPolicy(),
+		json:           *jsonOutput,
+		stdin:          os.Stdin,
+		stdout:         os.Stdout,
+	}
+	err = app.run(ctx, fs.Arg(0), fs.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin: %v\n", err)
+		cancel()
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// defaultDSN returns the DSN from the environment, using the same variables as the API.
+// A file given in SAINPR_DB_DSN_FILE takes precedence.
+func defaultDSN() string {
+	if path := os.Getenv("SAINPR_DB_DSN_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimSpace(string(content))
+		}
+	}
+	if dsn := os.Getenv("SAINPR_DB_DSN"); dsn != "" {
+		return dsn
+	}
+	return os.Getenv("sainpr")
+}
+
+// run dispatches to the named command.
+func (app *cli) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "create-user":
+		return app.createUser(ctx, args)
+	case "list-users":
+		return app.listUsers(ctx, args)
+	case "promote":
+		return app.setAdmin(ctx, command, args, true)
+	case "demote":
+		return app.setAdmin(ctx, command, args, false)
+	case "reset-password":
+		return app.resetPassword(ctx, args)
+	case "unlock":
+		return app.unlock(ctx, args)
+	case "assign":
+		return app.setAssignment(ctx, command, args, true)
+	case "unassign":
+		return app.setAssignment(ctx, command, args, false)
+	case "list-categories":
+		return app.listCategories(ctx, args)
+	default:
+		return fmt.Errorf("unknown command %q, run with -h for usage", command)
+	}
+}
+
+// output writes v as indented JSON if -json was given, and otherwise writes the
+// human-readable text.
+func (app *cli) output(v any, text string) error {
+	if app.json {
+		js, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(app.stdout, "%s\n", js)
+		return err
+	}
+	_, err := fmt.Fprintln(app.stdout, strings.TrimRight(text, "\n"))
+	return err
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"net/http"
 
 	"interview_assignment.mohamednaas.net/internal/data"
@@ -31,17 +30,14 @@ func (app *application) createCategoryHandler(w http.ResponseWriter, r *http.Req
 	v := validator.New()
 
 	if data.ValidateCategoryInsertion(v, category); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
 	}
 
 	// Valid input paramters, insert category into database
-	category.ID, err = app.models.Categories.CategoryCreate(*category)
+	category.ID, err = app.models.Categories.CategoryCreate(r.Context(), *category)
 	if err != nil {
-		if err == data.ErrDuplicateCategoryName {
-			app.badRequestResponse(w, r, err)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -68,18 +64,23 @@ func (app *application) getCategoriesHandler(w http.ResponseWriter, r *http.Requ
 	)
 	// Check if user is admin
 	user := app.contextGetUser(r)
+	isAdmin, err := app.hasAdminRights(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	if app.models.Users.IsAdmin(user.ID) {
+	if isAdmin {
 		// get all categories from DB
 
-		categories, err = app.models.Categories.CategoriesGet()
+		categories, err = app.models.Categories.CategoriesGet(r.Context())
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
 	} else {
-		categories, err = app.models.UserCategories.UserCategoriesGet(user.ID)
+		categories, err = app.models.UserCategories.UserCategoriesGet(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -101,14 +102,15 @@ func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Req
 	}
 	// Fetch the existing record from the database, sending a 404 Not Found
 	// response to the client if we couldn't find a matching record.
-	category, err := app.models.Categories.CategoryGet(id)
+	category, err := app.models.Categories.CategoryGet(r.Context(), id)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	// If the client sent an If-Match header, make sure it has the current version,
+	// sending a 412 Precondition Failed response otherwise.
+	if !app.ifMatch(r, category.Version) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
@@ -131,21 +133,71 @@ func (app *application) updateCategoryHandler(w http.ResponseWriter, r *http.Req
 	// response if any checks fail.
 	v := validator.New()
 	if data.ValidateCategoryInsertion(v, &category); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Categories.CategoryUpdate(category)
+	// The update only succeeds if the category is still at the version fetched above,
+	// otherwise someone else changed it in the meantime and the client gets a 409
+	// Conflict response.
+	err = app.models.Categories.CategoryUpdate(r.Context(), &category)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the updated  record in a JSON response, with the ETag of the new version.
+	w.Header().Set("ETag", etag(category.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
 	if err != nil {
-		if err == data.ErrDuplicateCategoryName {
-			app.badRequestResponse(w, r, err)
-			return
-		}
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Handler for partial updates of a category, where only the fields present in the
+// request are changed.
+func (app *application) patchCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	category, err := app.models.Categories.CategoryGet(r.Context(), id)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	if !app.ifMatch(r, category.Version) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
-	// Write the updated  record in a JSON response.
+	// A nil Name means the field wasn't sent, so the name is kept.
+	var input struct {
+		Name *string `json:"name"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Name != nil {
+		category.Name = *input.Name
+	}
+
+	v := validator.New()
+	if data.ValidateCategoryInsertion(v, &category); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Categories.CategoryUpdate(r.Context(), &category)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(category.Version))
 	err = app.writeJSON(w, http.StatusOK, envelope{"category": category}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -160,20 +212,26 @@ func (app *application) deleteCategoryHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// ensure category to be deleted exists
-	_, err = app.models.Categories.CategoryGet(id)
-	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
+	// Delete the category, this fails if it doesn't exist or is still assigned to a user.
+	// It is fetched first, in the same transaction, to check the If-Match header.
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		category, err := tx.Categories.CategoryGet(r.Context(), id)
+		if err != nil {
+			return err
 		}
-		app.serverErrorResponse(w, r, err)
+		if !app.ifMatch(r, category.Version) {
+			return errPreconditionFailed
+		}
+		return tx.Categories.CategoryDelete(r.Context(), id)
+	})
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	app.models.Categories.CategoryDelete(id)
-
 	// Delete sucessful, write response
-	app.writeJSON(w, http.StatusOK, envelope{"message": "category deleted successfully"}, nil)
-
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "category deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
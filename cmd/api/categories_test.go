@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+func TestCreateCategory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	_, userToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	tests := []struct {
+		name     string
+		body     any
+		token    string
+		wantCode int
+	}{
+		{"Anonymous", map[string]string{"name": "Books"}, "", http.StatusUnauthorized},
+		{"Non-admin", map[string]string{"name": "Books"}, userToken, http.StatusUnauthorized},
+		{"Admin", map[string]string{"name": "Books"}, adminToken, http.StatusCreated},
+		{"Duplicate name", map[string]string{"name": "Books"}, adminToken, http.StatusConflict},
+		{"Unknown field", map[string]string{"title": "Films"}, adminToken, http.StatusBadRequest},
+		{"Blank name", map[string]string{"name": " "}, adminToken, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/v1/categories", tt.body, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	// Only the valid category was created.
+	categories, err := app.models.Categories.CategoriesGet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 1 {
+		t.Errorf("got %d categories; want 1", len(categories))
+	}
+}
+
+func TestGetCategories(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	ctx := context.Background()
+	for _, name := range []string{"Books", "Films", "Music"} {
+		if _, err := app.models.Categories.CategoryCreate(ctx, data.Category{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.models.UserCategories.InsertUserCategories(ctx, alice.ID, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantCode  int
+		wantNames []string
+	}{
+		{"Anonymous", "", http.StatusUnauthorized, nil},
+		{"Admin sees every category", adminToken, http.StatusOK, []string{"Books", "Films", "Music"}},
+		{"User sees assigned categories", aliceToken, http.StatusOK, []string{"Films"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, "/v1/categories", nil, tt.token)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Categories []data.Category `json:"categories"`
+			}
+			decodeJSON(t, body, &resp)
+			var names []string
+			for _, c := range resp.Categories {
+				names = append(names, c.Name)
+			}
+			if len(names) != len(tt.wantNames) {
+				t.Fatalf("got categories %v; want %v", names, tt.wantNames)
+			}
+			for i := range names {
+				if names[i] != tt.wantNames[i] {
+					t.Fatalf("got categories %v; want %v", names, tt.wantNames)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateCategory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	for _, name := range []string{"Books", "Films"} {
+		if _, err := app.models.Categories.CategoryCreate(context.Background(), data.Category{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		body     map[string]string
+		wantCode int
+	}{
+		{"Valid", "/v1/categories/1", map[string]string{"name": "Novels"}, http.StatusOK},
+		{"Blank name", "/v1/categories/1", map[string]string{"name": ""}, http.StatusUnprocessableEntity},
+		{"Duplicate name", "/v1/categories/1", map[string]string{"name": "Films"}, http.StatusConflict},
+		{"Missing category", "/v1/categories/99", map[string]string{"name": "Games"}, http.StatusNotFound},
+		{"Invalid ID", "/v1/categories/abc", map[string]string{"name": "Games"}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPut, tt.urlPath, tt.body, adminToken)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	category, err := app.models.Categories.CategoryGet(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.Name != "Novels" {
+		t.Errorf("got category name %q; want %q", category.Name, "Novels")
+	}
+}
+
+func TestDeleteCategory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, userToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+	for _, name := range []string{"Books", "Films"} {
+		if _, err := app.models.Categories.CategoryCreate(context.Background(), data.Category{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.models.UserCategories.InsertUserCategories(context.Background(), alice.ID, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		token    string
+		wantCode int
+	}{
+		{"Non-admin", "/v1/categories/1", userToken, http.StatusUnauthorized},
+		{"Admin", "/v1/categories/1", adminToken, http.StatusOK},
+		{"Already deleted", "/v1/categories/1", adminToken, http.StatusNotFound},
+		{"Assigned to a user", "/v1/categories/2", adminToken, http.StatusConflict},
+		{"Invalid ID", "/v1/categories/0", adminToken, http.StatusBadRequest},
+		{"Non-numeric ID", "/v1/categories/abc", adminToken, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodDelete, tt.urlPath, nil, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	// A non-numeric ID gets the same message as any other invalid one, rather than the
+	// parser's error.
+	_, _, body := ts.do(t, http.MethodDelete, "/v1/categories/abc", nil, adminToken)
+	if !strings.Contains(string(body), "invalid id parameter") {
+		t.Errorf("got body %s; want the invalid id parameter message", body)
+	}
+}
+
+func TestConditionalCategoryRequests(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	if _, err := app.models.Categories.CategoryCreate(context.Background(), data.Category{Name: "Books"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The steps run in order, each update incrementing the version in the ETag.
+	steps := []struct {
+		name     string
+		method   string
+		ifMatch  string
+		wantCode int
+		wantETag string
+	}{
+		{"Update without If-Match", http.MethodPut, "", http.StatusOK, `"2"`},
+		{"Update with a stale ETag", http.MethodPut, `"1"`, http.StatusPreconditionFailed, ""},
+		{"Update with a weak ETag", http.MethodPut, `W/"2"`, http.StatusPreconditionFailed, ""},
+		{"Update with the current ETag", http.MethodPut, `"1", "2"`, http.StatusOK, `"3"`},
+		{"Update with a wildcard", http.MethodPut, "*", http.StatusOK, `"4"`},
+		{"Delete with a stale ETag", http.MethodDelete, `"3"`, http.StatusPreconditionFailed, ""},
+		{"Delete with the current ETag", http.MethodDelete, `"4"`, http.StatusOK, ""},
+	}
+
+	for i, step := range steps {
+		var body any
+		if step.method == http.MethodPut {
+			body = map[string]string{"name": fmt.Sprintf("Books %d", i)}
+		}
+		header := http.Header{}
+		if step.ifMatch != "" {
+			header.Set("If-Match", step.ifMatch)
+		}
+
+		code, rsHeader, rsBody := ts.doWithHeader(t, step.method, "/v1/categories/1", body, adminToken, header)
+		if code != step.wantCode {
+			t.Fatalf("%s: got status %d; want %d (body %s)", step.name, code, step.wantCode, rsBody)
+		}
+		if got := rsHeader.Get("ETag"); got != step.wantETag {
+			t.Errorf("%s: got ETag %q; want %q", step.name, got, step.wantETag)
+		}
+	}
+}
+
+func TestPatchCategory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	if _, err := app.models.Categories.CategoryCreate(context.Background(), data.Category{Name: "Books"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+		wantName string
+	}{
+		{"No fields", map[string]string{}, http.StatusOK, "Books"},
+		{"Blank name", map[string]string{"name": ""}, http.StatusUnprocessableEntity, "Books"},
+		{"Name", map[string]string{"name": "Novels"}, http.StatusOK, "Novels"},
+		{"Null name", `{"name": null}`, http.StatusOK, "Novels"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPatch, "/v1/categories/1", tt.body, adminToken)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			category, err := app.models.Categories.CategoryGet(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if category.Name != tt.wantName {
+				t.Errorf("got name %q; want %q", category.Name, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/password"
+)
+
+type config struct {
+	port       int
+	adminAddr  string
+	env        string
+	logLevel   string
+	pictureDir string
+	db         struct {
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  string
+		migrate      bool
+		// timeout limits every model operation, and operationTimeouts overrides it
+		// for individual operations.
+		timeout           time.Duration
+		operationTimeouts durationMap
+	}
+	jwt struct {
+		secret string // Add a new field to store the JWT signing secret.
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	shutdown struct {
+		delay   time.Duration
+		timeout time.Duration
+	}
+	tracing struct {
+		exporter    string
+		endpoint    string
+		insecure    bool
+		file        string
+		sampleRatio float64
+	}
+	cors struct {
+		trustedOrigins stringList
+	}
+	// password holds the policy for new passwords, see password.Policy, and how they are
+	// hashed, see password.Hasher.
+	password struct {
+		minLength         int
+		maxBytes          int
+		minClasses        int
+		forbidPersonal    bool
+		minScore          int
+		breachedCheck     bool
+		breachedFilter    string
+		hashAlgorithm     string
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+		bcryptCost        int
+	}
+	// login limits failed logins per account and per client; see lockout.go.
+	login struct {
+		lockoutEnabled     bool
+		maxAccountFailures int
+		maxClientFailures  int
+		lockoutDuration    time.Duration
+	}
+	// mfa configures two-factor authentication; see mfa.go.
+	mfa struct {
+		requireAdmin bool
+		issuer       string
+		challengeTTL time.Duration
+	}
+	features    stringList
+	maintenance bool
+}
+
+// envPrefix is prepended to the upper-cased setting name to form the name of the
+// environment variable for that setting, e.g. db-max-open-conns is read from
+// SAINPR_DB_MAX_OPEN_CONNS.
+const envPrefix = "SAINPR_"
+
+// secretSettings lists the settings which must never be printed. Each of them can also
+// be read from a file by setting "<name>-file" instead (SAINPR_<NAME>_FILE in the
+// environment), which is how secrets are usually mounted into containers.
+var secretSettings = []string{"db-dsn", "jwt-secret"}
+
+// legacyEnv maps the environment variables used before the SAINPR_ prefix was
+// introduced to the settings they configure. They are still honoured, but only when the
+// prefixed variable isn't set.
+var legacyEnv = map[string]string{
+	"db-dsn":      "sainpr",
+	"picture-dir": "sainpr_pfp_dir",
+	"jwt-secret":  "JWT_SECRET",
+}
+
+// loadConfig builds the configuration from, in increasing order of precedence, the
+// built-in defaults, the YAML file given with -config, environment variables and
+// command-line flags. Every setting has the same name in all three places: the flag
+// name, a key in the config file (nested maps are joined with "-", so db: {dsn: ...}
+// is equivalent to db-dsn: ...), and the prefixed environment variable.
+//
+// All validation errors are returned together. Any arguments after the flags are
+// returned as the command to run (e.g. "migrate up"). If -print-config was given, the
+// resolved configuration is written to stdout with secrets redacted, and printOnly is
+// returned as true.
+func loadConfig(args []string) (cfg config, command []string, printOnly bool, err error) {
+	fs := newConfigFlagSet(&cfg)
+
+	var (
+		configFile  string
+		printConfig bool
+	)
+	fs.StringVar(&configFile, "config", os.Getenv(envPrefix+"CONFIG"), "Path to a YAML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the resolved configuration (secrets redacted) and exit")
+
+	secretFiles := make(map[string]*string, len(secretSettings))
+	for _, name := range secretSettings {
+		secretFiles[name] = fs.String(name+"-file", "", "File containing the "+name+" secret")
+	}
+
+	// Parsing the flags first sets the values given on the command line; the file and
+	// environment are then only applied to the settings which weren't.
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, false, err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var errs []error
+	set := func(name, value, source string) {
+		if explicit[name] || name == "config" || name == "print-config" {
+			return
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", source, name))
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value for %s: %v", source, name, err))
+		}
+	}
+
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return cfg, nil, false, err
+		}
+		for name, value := range values {
+			set(name, value, configFile)
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		envName := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(envName); ok {
+			set(f.Name, value, envName)
+		} else if legacy, ok := legacyEnv[f.Name]; ok {
+			if value, ok := os.LookupEnv(legacy); ok {
+				set(f.Name, value, legacy)
+			}
+		}
+	})
+
+	for _, name := range secretSettings {
+		path := secretFiles[name]
+		if *path == "" {
+			continue
+		}
+		if fs.Lookup(name).Value.String() != "" {
+			errs = append(errs, fmt.Errorf("only one of %s and %s-file may be set", name, name))
+			continue
+		}
+		content, err := os.ReadFile(*path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s-file: %v", name, err))
+			continue
+		}
+		fs.Lookup(name).Value.Set(strings.TrimSpace(string(content)))
+	}
+
+	// The migrate command only uses the database, so the rest of the configuration,
+	// such as the JWT secret, needn't be given to run it.
+	if command := fs.Args(); len(command) > 0 && command[0] == "migrate" {
+		errs = append(errs, cfg.validateDB()...)
+	} else {
+		errs = append(errs, cfg.validate()...)
+	}
+	if len(errs) > 0 {
+		return cfg, nil, false, errors.Join(errs...)
+	}
+
+	if printConfig {
+		return cfg, fs.Args(), true, writeConfig(os.Stdout, fs)
+	}
+	return cfg, fs.Args(), false, nil
+}
+
+// newConfigFlagSet returns a flag set with one flag per setting, bound to the fields of
+// cfg. Registering the flags resets the fields to their default values.
+func newConfigFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.adminAddr, "admin-addr", "localhost:4001", "Admin listener address serving /metrics (empty to disable)")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.logLevel, "log-level", "info", "Minimum log level (debug|info|warn|error)")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "Database DSN (postgres://... or sqlite:path/to/file.db)")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "Database max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "Database max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "Database max connection idle time")
+	fs.StringVar(&cfg.pictureDir, "picture-dir", "./userdata/static/profile_pictures", "Directory where users profile images are stored")
+	fs.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT signing secret")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.db.migrate, "db-migrate", false, "Apply pending database migrations on startup")
+	fs.DurationVar(&cfg.db.timeout, "db-timeout", 3*time.Second, "Maximum time a database operation may take (0 for no limit)")
+	cfg.db.operationTimeouts = durationMap{}
+	fs.Var(&cfg.db.operationTimeouts, "db-operation-timeouts", "Timeouts for individual database operations, as Operation=duration pairs (e.g. UsersGet=10s)")
+
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	fs.DurationVar(&cfg.shutdown.delay, "shutdown-delay", 0, "Time to keep serving after readiness starts failing on shutdown")
+	fs.DurationVar(&cfg.shutdown.timeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+
+	fs.StringVar(&cfg.tracing.exporter, "tracing-exporter", "none", "Trace exporter (none|otlp|stdout)")
+	fs.StringVar(&cfg.tracing.endpoint, "tracing-endpoint", "", "OTLP/HTTP collector host:port (defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.BoolVar(&cfg.tracing.insecure, "tracing-insecure", false, "Use plain HTTP for the OTLP exporter")
+	fs.StringVar(&cfg.tracing.file, "tracing-file", "", "File the stdout exporter writes spans to (defaults to stdout)")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "tracing-sample-ratio", 1, "Fraction of new traces to sample (0-1)")
+
+	fs.IntVar(&cfg.password.minLength, "password-min-length", 8, "Minimum number of characters in a password")
+	fs.IntVar(&cfg.password.maxBytes, "password-max-bytes", 72, "Maximum length of a password in bytes (at most 72, bcrypt's limit, when hashing with bcrypt)")
+	fs.IntVar(&cfg.password.minClasses, "password-min-classes", 1, "Number of character classes (lowercase, uppercase, digits, symbols) a password must use")
+	fs.BoolVar(&cfg.password.forbidPersonal, "password-forbid-personal", true, "Reject passwords containing the user's name or email address")
+	fs.IntVar(&cfg.password.minScore, "password-min-score", 0, "Minimum estimated password strength, from 0 to 4")
+	fs.BoolVar(&cfg.password.breachedCheck, "password-breached-check", true, "Reject passwords found in the breached password filter")
+	fs.StringVar(&cfg.password.breachedFilter, "password-breached-filter", "", "Breached password filter file to use instead of the bundled one")
+	hasher := password.DefaultHasher()
+	fs.StringVar(&cfg.password.hashAlgorithm, "password-hash-algorithm", hasher.Algorithm, "Algorithm new password hashes are made with (argon2id|bcrypt)")
+	fs.UintVar(&cfg.password.argon2Memory, "password-argon2-memory", uint(hasher.Argon2.Memory), "Memory used by argon2id, in KiB")
+	fs.UintVar(&cfg.password.argon2Iterations, "password-argon2-iterations", uint(hasher.Argon2.Iterations), "Number of argon2id iterations")
+	fs.UintVar(&cfg.password.argon2Parallelism, "password-argon2-parallelism", uint(hasher.Argon2.Parallelism), "Number of argon2id threads")
+	fs.IntVar(&cfg.password.bcryptCost, "password-bcrypt-cost", hasher.BcryptCost, "bcrypt cost")
+
+	fs.BoolVar(&cfg.login.lockoutEnabled, "login-lockout-enabled", true, "Slow down and lock out logins after failed attempts")
+	fs.IntVar(&cfg.login.maxAccountFailures, "login-max-account-failures", 10, "Failed logins after which an account is locked out")
+	fs.IntVar(&cfg.login.maxClientFailures, "login-max-client-failures", 100, "Failed logins after which a client IP is locked out")
+	fs.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", 15*time.Minute, "How long a lockout lasts, and how long failed logins are remembered")
+
+	fs.BoolVar(&cfg.mfa.requireAdmin, "mfa-require-admin", false, "Require admins to set up two-factor authentication before using admin rights")
+	fs.StringVar(&cfg.mfa.issuer, "mfa-issuer", "sainpr", "Name authenticator apps show for the account")
+	fs.DurationVar(&cfg.mfa.challengeTTL, "mfa-challenge-ttl", 5*time.Minute, "How long the second step of a two-factor login may take")
+
+	fs.Var(&cfg.cors.trustedOrigins, "cors-trusted-origins", "Trusted CORS origins (space or comma separated)")
+	cfg.features = stringList{"registration"}
+	fs.Var(&cfg.features, "features", "Enabled feature toggles (space or comma separated)")
+	fs.BoolVar(&cfg.maintenance, "maintenance", false, "Reject API requests with 503 Service Unavailable")
+
+	return fs
+}
+
+// readConfigFile reads a YAML config file and flattens it into setting names and
+// string values, ready to be passed to flag.Value.Set().
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]any
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	values := make(map[string]string)
+	flattenConfig("", tree, values)
+	return values, nil
+}
+
+func flattenConfig(prefix string, tree map[string]any, values map[string]string) {
+	for key, value := range tree {
+		name := strings.ReplaceAll(key, "_", "-")
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+		switch value := value.(type) {
+		case nil:
+			// An empty section or value; nothing to set.
+		case map[string]any:
+			flattenConfig(name, value, values)
+		case []any:
+			// Lists are passed on in the same space separated form used by the
+			// flags and environment variables.
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, " ")
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+}
+
+// validate checks the resolved configuration, returning every problem found rather
+// than stopping at the first one.
+func (cfg config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.port > 0 && cfg.port <= 65535, "port must be between 1 and 65535")
+	check(cfg.env == "development" || cfg.env == "staging" || cfg.env == "production",
+		"env must be one of development, staging or production")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.logLevel)) == nil, "log-level must be one of debug, info, warn or error")
+
+	errs = append(errs, cfg.validateDB()...)
+
+	check(cfg.pictureDir != "", "picture-dir must be provided")
+	check(cfg.jwt.secret != "", "jwt-secret must be provided")
+	check(cfg.env != "production" || len(cfg.jwt.secret) >= 32, "jwt-secret must be at least 32 characters in production")
+
+	check(!cfg.limiter.enabled || cfg.limiter.rps > 0, "limiter-rps must be greater than zero")
+	check(!cfg.limiter.enabled || cfg.limiter.burst > 0, "limiter-burst must be greater than zero")
+
+	check(cfg.shutdown.delay >= 0, "shutdown-delay must not be negative")
+	check(cfg.shutdown.timeout > 0, "shutdown-timeout must be greater than zero")
+
+	check(cfg.tracing.exporter == "none" || cfg.tracing.exporter == "otlp" || cfg.tracing.exporter == "stdout",
+		"tracing-exporter must be one of none, otlp or stdout")
+	check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "tracing-sample-ratio must be between 0 and 1")
+
+	check(cfg.password.minLength > 0, "password-min-length must be greater than zero")
+	check(cfg.password.maxBytes >= cfg.password.minLength, "password-max-bytes must be at least password-min-length")
+	check(cfg.password.hashAlgorithm != password.Bcrypt || cfg.password.maxBytes <= 72,
+		"password-max-bytes must be at most 72 with bcrypt, which ignores anything longer")
+	check(cfg.password.argon2Memory <= math.MaxUint32, "password-argon2-memory is too large")
+	check(cfg.password.argon2Iterations <= math.MaxUint32, "password-argon2-iterations is too large")
+	check(cfg.password.argon2Parallelism <= math.MaxUint8, "password-argon2-parallelism must be at most 255")
+	err := newPasswordHasher(cfg).Validate()
+	check(err == nil, "password: %v", err)
+	check(cfg.password.minClasses >= 0 && cfg.password.minClasses <= 4, "password-min-classes must be between 0 and 4")
+	check(cfg.password.minScore >= 0 && cfg.password.minScore <= 4, "password-min-score must be between 0 and 4")
+
+	check(!cfg.login.lockoutEnabled || cfg.login.maxAccountFailures > 0, "login-max-account-failures must be greater than zero")
+	check(!cfg.login.lockoutEnabled || cfg.login.maxClientFailures > 0, "login-max-client-failures must be greater than zero")
+	check(!cfg.login.lockoutEnabled || cfg.login.lockoutDuration > 0, "login-lockout-duration must be greater than zero")
+
+	check(cfg.mfa.issuer != "" && !strings.Contains(cfg.mfa.issuer, ":"), "mfa-issuer must be provided and must not contain a colon")
+	check(cfg.mfa.challengeTTL > 0, "mfa-challenge-ttl must be greater than zero")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		check(strings.HasPrefix(origin, "http://") || strings.HasPrefix(origin, "https://"),
+			"cors-trusted-origins: %q must start with http:// or https://", origin)
+	}
+
+	return errs
+}
+
+// validateDB checks only the database settings. They are all the migrate command uses,
+// so it can be run without the server's secrets.
+func (cfg config) validateDB() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.db.dsn != "", "db-dsn must be provided")
+	if cfg.db.dsn != "" {
+		_, err := data.ParseDSN(cfg.db.dsn)
+		check(err == nil, "db-dsn: %v", err)
+	}
+	check(cfg.db.maxOpenConns > 0, "db-max-open-conns must be greater than zero")
+	check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns must not be negative")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	check(err == nil, "db-max-idle-time must be a duration such as 15m")
+	check(cfg.db.timeout >= 0, "db-timeout must not be negative")
+	for _, name := range cfg.db.operationTimeouts.names() {
+		check(data.IsOperation(name), "db-operation-timeouts: unknown operation %q", name)
+		check(cfg.db.operationTimeouts[name] > 0, "db-operation-timeouts: %s must be greater than zero", name)
+	}
+
+	return errs
+}
+
+// writeConfig writes every setting in fs as a flat YAML document which can be used as
+// a config file. Secrets are replaced with a placeholder.
+func writeConfig(w io.Writer, fs *flag.FlagSet) error {
+	secret := make(map[string]bool)
+	for _, name := range secretSettings {
+		secret[name] = true
+	}
+
+	values := make(map[string]any)
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		// Use the typed value where there is one, so that numbers and booleans aren't
+		// quoted in the output. Durations are kept in their string form (e.g. 15m).
+		var value any = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if _, isDuration := getter.Get().(time.Duration); !isDuration {
+				value = getter.Get()
+			}
+		}
+		if secret[f.Name] && f.Value.String() != "" {
+			value = "[REDACTED]"
+		}
+		values[f.Name] = value
+		names = append(names, f.Name)
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := yaml.Marshal(values[name])
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s", name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The durationMap type is a flag.Value holding durations by name, given as a comma or
+// space separated list of name=duration pairs (e.g. -db-operation-timeouts="UsersGet=10s
+// CategoriesGet=1s").
+type durationMap map[string]time.Duration
+
+// names returns the names in the map in sorted order.
+func (m *durationMap) names() []string {
+	names := make([]string, 0, len(*m))
+	for name := range *m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *durationMap) String() string {
+	var pairs []string
+	for _, name := range m.names() {
+		pairs = append(pairs, name+"="+(*m)[name].String())
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (m *durationMap) Set(value string) error {
+	parsed := make(durationMap)
+	for _, pair := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name, duration, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q must have the form name=duration", pair)
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return fmt.Errorf("%q: %w", pair, err)
+		}
+		parsed[name] = d
+	}
+	*m = parsed
+	return nil
+}
@@ -19,6 +19,10 @@ const userContextKey = contextKey("user")
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// Record the user ID for the access log as well.
+	if entry := app.contextGetAccessLog(r); entry != nil {
+		entry.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -34,3 +38,43 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// requestIDContextKey is used to store the ID assigned to each request by the
+// requestID() middleware.
+const requestIDContextKey = contextKey("request_id")
+
+// accessLogContextKey is used to store a pointer to the accessLogEntry for the current
+// request. The authenticate() middleware runs further down the chain than logRequest(),
+// so it records the authenticated user through this pointer rather than through a new
+// request copy, which logRequest() would never see.
+const accessLogContextKey = contextKey("access_log")
+
+// accessLogEntry holds the request information which is only known once the request
+// has moved further down the middleware chain.
+type accessLogEntry struct {
+	route   string
+	traceID string
+	userID  int
+}
+
+// The contextSetRequestID() method returns a new copy of the request with the given
+// request ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method returns the ID of the current request, or an empty
+// string if the requestID() middleware hasn't run (for example in handlers which are
+// called directly).
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// The contextGetAccessLog() method returns the access log entry for the current
+// request, or nil if the logRequest() middleware hasn't run.
+func (app *application) contextGetAccessLog(r *http.Request) *accessLogEntry {
+	entry, _ := r.Context().Value(accessLogContextKey).(*accessLogEntry)
+	return entry
+}
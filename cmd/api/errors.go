@@ -1,87 +1,352 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/language"
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/i18n"
+	"interview_assignment.mohamednaas.net/internal/validator"
 )
 
-// The logError() method is a generic helper for logging an error message. Later in the
-// book we'll upgrade this to use structured logging, and record additional information
-// about the request including the HTTP method and URL.
+// errPreconditionFailed is returned from a transaction when the record it was about to
+// change doesn't match the request's If-Match header.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// The logError() method is a generic helper for logging an error message along with
+// the request ID, HTTP method, URL, authenticated user and trace ID, so that the entry
+// can be correlated with the access log line and trace for the same request.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Print(err)
+	var (
+		method = r.Method
+		uri    = r.URL.RequestURI()
+		userID int
+	)
+	if entry := app.contextGetAccessLog(r); entry != nil {
+		userID = entry.userID
+	}
+	// Attach the error to the active span as well, so it shows up in the trace.
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	app.logger.Error(err.Error(), "request_id", app.contextGetRequestID(r), "method", method, "uri", uri,
+		"user_id", userID, "trace_id", span.SpanContext().TraceID().String())
+}
+
+// The language() method returns the language to send messages to the client in, picked
+// from the languages we have messages for by the request's Accept-Language header.
+func (app *application) language(r *http.Request) language.Tag {
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// The translate() method returns the message with the given key in the client's
+// language.
+func (app *application) translate(r *http.Request, key string, args ...any) string {
+	return i18n.Message(app.language(r), key, args...)
+}
+
+// problemTypeBase is the prefix of the type URI of every problem details response. The
+// last path segment is the problem's code, and the codes are listed in the README.
+const problemTypeBase = "/problems/"
+
+// problem is an RFC 7807 problem details object. Code is a machine-readable identifier
+// for the kind of error which, unlike the title and detail, won't change between
+// releases.
+type problem struct {
+	Type          string         `json:"type"`
+	Code          string         `json:"code"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+	// Extensions are further members specific to the problem, such as the strength of a
+	// rejected password, which are added alongside the standard ones.
+	Extensions map[string]any `json:"-"`
+}
+
+// MarshalJSON adds the extension members to the standard ones.
+func (p problem) MarshalJSON() ([]byte, error) {
+	type standard problem
+	js, err := json.Marshal(standard(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return js, err
+	}
+	members := make(map[string]any)
+	if err := json.Unmarshal(js, &members); err != nil {
+		return nil, err
+	}
+	for name, value := range p.Extensions {
+		if _, exists := members[name]; !exists {
+			members[name] = value
+		}
+	}
+	return json.Marshal(members)
+}
+
+// invalidParam describes a request field which failed validation.
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// wantsProblem reports whether the request's Accept header lists
+// application/problem+json. Problem details are opt-in, so that existing clients keep
+// getting the {"error": ...} responses they know how to handle.
+func wantsProblem(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || mediaType != "application/problem+json" {
+				continue
+			}
+			// A quality of zero means the client doesn't accept the type at all.
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
 }
 
 // The errorResponse() method is a generic helper for sending JSON-formatted error
-// messages to the client with a given status code.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
-	// Write the response using the writeJSON() helper. If this happens to return an
-	// error then log it, and fall back to sending the client an empty response with a
-	// 500 Internal Server Error status code.
-	err := app.writeJSON(w, status, env, nil)
+// messages to the client with a given status code. The code identifies the kind of
+// error in problem details responses. The message is either a string or, for validation
+// errors, a map of field names to what is wrong with them.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	app.errorResponseWithDetails(w, r, status, code, message, nil)
+}
+
+// The errorResponseWithDetails() method is like errorResponse(), but also sends the
+// details, which are added next to "error", or as extension members of problem details.
+func (app *application) errorResponseWithDetails(w http.ResponseWriter, r *http.Request, status int, code string, message any, details map[string]any) {
+	w.Header().Add("Vary", "Accept, Accept-Language")
+	w.Header().Set("Content-Language", app.language(r).String())
+
+	var err error
+	if wantsProblem(r) {
+		p := app.newProblem(r, status, code, message)
+		p.Extensions = details
+		err = app.writeProblem(w, p)
+	} else {
+		env := envelope{"error": message}
+		for key, value := range details {
+			env[key] = value
+		}
+		// Write the response using the writeJSON() helper.
+		err = app.writeJSON(w, status, env, nil)
+	}
+	// If writing the response returned an error then log it, and fall back to sending
+	// the client an empty response with a 500 Internal Server Error status code.
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// newProblem builds the problem details for an error response.
+func (app *application) newProblem(r *http.Request, status int, code string, message any) problem {
+	p := problem{
+		Type:     problemTypeBase + code,
+		Code:     code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: app.contextGetRequestID(r),
+	}
+	switch message := message.(type) {
+	case string:
+		p.Detail = message
+	case map[string]string:
+		p.Detail = "one or more request fields are invalid"
+		for name, reason := range message {
+			p.InvalidParams = append(p.InvalidParams, invalidParam{Name: name, Reason: reason})
+		}
+		// Map iteration order is random, so sort the fields to keep responses stable.
+		sort.Slice(p.InvalidParams, func(i, j int) bool {
+			return p.InvalidParams[i].Name < p.InvalidParams[j].Name
+		})
+	}
+	return p
+}
+
+// writeProblem writes p as an application/problem+json response.
+func (app *application) writeProblem(w http.ResponseWriter, p problem) error {
+	js, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(js)
+	return nil
+}
+
 // The serverErrorResponse() method will be used when application encounters an
 // unexpected problem at runtime. It logs the detailed error message, then uses the
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
 // response (containing a generic error message) to the client.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	message := app.translate(r, "error.server_error")
+	app.errorResponse(w, r, http.StatusInternalServerError, "server_error", message)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	message := app.translate(r, "error.not_found")
+	app.errorResponse(w, r, http.StatusNotFound, "not_found", message)
 }
 
 // The methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed
 // status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	message := app.translate(r, "error.method_not_allowed", r.Method)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", message)
 }
 
-// Used when there's a problem with request
+// Used when there's a problem with request. The errors returned by the request helpers
+// are translated; any other error's message is sent as it is.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	message := err.Error()
+	var i18nErr *i18n.Error
+	if errors.As(err, &i18nErr) {
+		message = app.translate(r, i18nErr.Key, i18nErr.Args...)
+	}
+	app.errorResponse(w, r, http.StatusBadRequest, "bad_request", message)
 }
 
-// Note that the errors parameter here has the type map[string]string, which is exactly
-// the same as the errors map contained in our Validator type.
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// The failedValidationResponse() method sends the errors collected by a Validator, each
+// translated into the client's language, along with any details about them.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	messages := make(map[string]string, len(v.Errors))
+	for field, msg := range v.Errors {
+		messages[field] = app.translate(r, msg.Key, msg.Args...)
+	}
+	app.errorResponseWithDetails(w, r, http.StatusUnprocessableEntity, "validation_failed", messages, v.Details)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	message := app.translate(r, "error.invalid_credentials")
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_credentials", message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
-	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	message := app.translate(r, "error.rate_limit_exceeded")
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate_limit_exceeded", message)
 }
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	message := app.translate(r, "error.invalid_token")
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_token", message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	message := app.translate(r, "error.authentication_required")
+	app.errorResponse(w, r, http.StatusUnauthorized, "authentication_required", message)
+}
+
+func (app *application) maintenanceModeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "120")
+	message := app.translate(r, "error.maintenance")
+	app.errorResponse(w, r, http.StatusServiceUnavailable, "maintenance", message)
+}
+
+func (app *application) featureDisabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.feature_disabled")
+	app.errorResponse(w, r, http.StatusForbidden, "feature_disabled", message)
+}
+
+// The editConflictResponse() method is used when a record was changed by someone else
+// between reading it and writing the update back.
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.edit_conflict")
+	app.errorResponse(w, r, http.StatusConflict, "edit_conflict", message)
+}
+
+// The preconditionFailedResponse() method is used when the If-Match header doesn't
+// match the record's current ETag, meaning the client's copy is out of date.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.precondition_failed")
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "precondition_failed", message)
 }
 
 func (app *application) adminAuthenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated as an ADMIN to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	message := app.translate(r, "error.admin_required")
+	app.errorResponse(w, r, http.StatusUnauthorized, "admin_required", message)
+}
+
+// The notPermittedResponse() method is sent when the user may reach the resource, but not
+// make the request, such as enrolling someone else in two-factor authentication.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.not_permitted")
+	app.errorResponse(w, r, http.StatusForbidden, "not_permitted", message)
+}
+
+// The dataErrorResponse() method sends the response for an error returned by the
+// models, so that every handler reports the same kind of error with the same status:
+// 404 Not Found for a missing record, 409 Conflict for a clash with an existing record
+// or an edit conflict, 412 Precondition Failed when If-Match didn't match, and 422
+// Unprocessable Entity for a reference to a missing record or a value the database
+// rejects. Anything else is a 500 Internal Server Error.
+func (app *application) dataErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// The message is looked up by the error's code rather than taken from the error,
+	// since a ConstraintError's message includes the driver's error, which isn't meant
+	// for clients.
+	code := dataErrorCode(err)
+	message := app.translate(r, "error."+code)
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, errPreconditionFailed):
+		app.preconditionFailedResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrConflict):
+		app.errorResponse(w, r, http.StatusConflict, code, message)
+	case errors.Is(err, data.ErrForeignKeyViolation), errors.Is(err, data.ErrCheckViolation):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, code, message)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// dataErrorCodes are the problem codes for the errors returned by the models, most
+// specific first, so that clients can tell e.g. a duplicate email from a duplicate
+// category name without parsing the message.
+var dataErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{data.ErrDuplicateEmail, "duplicate_email"},
+	{data.ErrDuplicateCategoryName, "duplicate_category_name"},
+	{data.ErrCategoryAlreadyAssigned, "category_already_assigned"},
+	{data.ErrRecordInUse, "record_in_use"},
+	{data.ErrCannotDeleteAdmin, "cannot_delete_admin"},
+	{data.ErrConflict, "conflict"},
+	{data.ErrForeignKeyViolation, "invalid_reference"},
+	{data.ErrCheckViolation, "invalid_value"},
+}
+
+// dataErrorCode returns the problem code for an error returned by the models.
+func dataErrorCode(err error) string {
+	for _, c := range dataErrorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return "server_error"
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestProblemDetails(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	createTestUser(t, app, "Alice", "alice@example.com", false)
+	problemJSON := http.Header{"Accept": {"application/json, application/problem+json"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		urlPath    string
+		body       any
+		wantStatus int
+		wantCode   string
+		wantParams []invalidParam
+	}{
+		{"Not found", http.MethodGet, "/v1/nothing", nil, http.StatusNotFound, "not_found", nil},
+		{"Authentication required", http.MethodGet, "/v1/users/me", nil, http.StatusUnauthorized, "authentication_required", nil},
+		{"Duplicate email", http.MethodPost, "/v1/users", map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"},
+			http.StatusConflict, "duplicate_email", nil},
+		{"Validation", http.MethodPost, "/v1/users", map[string]string{"name": "Bob", "email": "bob", "password": "short"},
+			http.StatusUnprocessableEntity, "validation_failed", []invalidParam{
+				{"email", "Email must be a valid address"},
+				{"password", "Password must be at least 8 characters long"},
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, header, body := ts.doWithHeader(t, tt.method, tt.urlPath, tt.body, "", problemJSON)
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (body %s)", status, tt.wantStatus, body)
+			}
+			if ct := header.Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("got Content-Type %q; want application/problem+json", ct)
+			}
+
+			var p problem
+			decodeJSON(t, body, &p)
+			if p.Code != tt.wantCode || p.Type != problemTypeBase+tt.wantCode || p.Status != tt.wantStatus {
+				t.Errorf("got problem %+v; want code %q", p, tt.wantCode)
+			}
+			if p.Title != http.StatusText(tt.wantStatus) || p.Instance != header.Get("X-Request-ID") {
+				t.Errorf("got title %q and instance %q; want the status text and request ID", p.Title, p.Instance)
+			}
+			if !reflect.DeepEqual(p.InvalidParams, tt.wantParams) {
+				t.Errorf("got invalid_params %+v; want %+v", p.InvalidParams, tt.wantParams)
+			}
+		})
+	}
+
+	// Without the Accept header clients get the original error format.
+	status, header, body := ts.do(t, http.MethodGet, "/v1/nothing", nil, "")
+	if status != http.StatusNotFound || header.Get("Content-Type") != "application/json" {
+		t.Errorf("got status %d and Content-Type %q; want a 404 in application/json", status, header.Get("Content-Type"))
+	}
+	var legacy map[string]string
+	decodeJSON(t, body, &legacy)
+	if legacy["error"] == "" {
+		t.Errorf("got body %s; want an error message", body)
+	}
+
+	// q=0 explicitly refuses problem details.
+	refused := http.Header{"Accept": {"application/problem+json;q=0"}}
+	if _, header, _ := ts.doWithHeader(t, http.MethodGet, "/v1/nothing", nil, "", refused); header.Get("Content-Type") != "application/json" {
+		t.Errorf("got Content-Type %q with q=0; want application/json", header.Get("Content-Type"))
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	arabic := http.Header{"Accept-Language": {"ar-SA,ar;q=0.9,en;q=0.5"}}
+
+	status, header, body := ts.doWithHeader(t, http.MethodGet, "/v1/nothing", nil, "", arabic)
+	if status != http.StatusNotFound || header.Get("Content-Language") != "ar" {
+		t.Fatalf("got status %d and Content-Language %q; want a 404 in ar", status, header.Get("Content-Language"))
+	}
+	var resp struct {
+		Error any `json:"error"`
+	}
+	decodeJSON(t, body, &resp)
+	if resp.Error != "تعذر العثور على المورد المطلوب" {
+		t.Errorf("got error %q; want the Arabic message", resp.Error)
+	}
+
+	_, _, body = ts.doWithHeader(t, http.MethodPost, "/v1/users", map[string]string{"email": "bob"}, "", arabic)
+	var validation struct {
+		Error map[string]string `json:"error"`
+	}
+	decodeJSON(t, body, &validation)
+	want := map[string]string{
+		"name":     "يجب إدخال الاسم",
+		"email":    "يجب أن يكون البريد الإلكتروني عنوانًا صالحًا",
+		"password": "يجب إدخال كلمة المرور",
+	}
+	if !reflect.DeepEqual(validation.Error, want) {
+		t.Errorf("got validation errors %v; want %v", validation.Error, want)
+	}
+
+	// Clients which don't ask for a language we support get English.
+	_, header, _ = ts.doWithHeader(t, http.MethodGet, "/v1/nothing", nil, "", http.Header{"Accept-Language": {"fr"}})
+	if header.Get("Content-Language") != "en" {
+		t.Errorf("got Content-Language %q; want en", header.Get("Content-Language"))
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 )
 
 // Declare a handler which writes a plain-text response with information about the
@@ -17,3 +22,100 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The livenessHandler() reports that the process is up and able to serve requests. It
+// deliberately doesn't check any dependencies, so that an unavailable database doesn't
+// get the server restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readinessHandler() reports whether the server should receive traffic. It fails
+// once shutdown has started, if the database can't be reached, or if the profile
+// picture directory isn't writable. Each check is reported individually. The endpoint is
+// public, so a failed check is only reported as unavailable, and the error, which may
+// name hosts or paths, goes to the log. For the same reason the storage check, which
+// writes a file, is only repeated every storageCheckInterval.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"shutdown": "ok",
+		"database": "ok",
+		"storage":  "ok",
+	}
+	ready := true
+
+	if app.shuttingDown.Load() {
+		checks["shutdown"] = "shutting down"
+		ready = false
+	}
+
+	// The database is nil when the application runs against the in-memory models, as
+	// it does in the tests.
+	if app.db == nil {
+		checks["database"] = "skipped"
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := app.db.PingContext(ctx); err != nil {
+			app.logError(r, fmt.Errorf("readiness: database: %w", err))
+			checks["database"] = "unavailable"
+			ready = false
+		}
+	}
+
+	if err := app.checkStorage(); err != nil {
+		app.logError(r, fmt.Errorf("readiness: storage: %w", err))
+		checks["storage"] = "unavailable"
+		ready = false
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+
+	err := app.writeJSON(w, code, envelope{"status": status, "checks": checks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// storageCheckInterval is how long the result of the readiness probe's storage check
+// is reused for before the picture directory is checked again.
+const storageCheckInterval = 10 * time.Second
+
+// storageCheck holds the result of the last storage check, and when it was made.
+type storageCheck struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// The checkStorage() method reports whether the picture directory is writable, checking
+// it again only if the last result is older than storageCheckInterval.
+func (app *application) checkStorage() error {
+	c := &app.storageCheck
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checked.IsZero() && time.Since(c.checked) < storageCheckInterval {
+		return c.err
+	}
+	c.err = checkWritable(app.config.pictureDir)
+	c.checked = time.Now()
+	return c.err
+}
+
+// checkWritable verifies that files can be created in dir by writing and removing a
+// temporary file.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		wantStatus string
+	}{
+		{"Healthcheck", "/v1/healthcheck", "available"},
+		{"Liveness", "/livez", "alive"},
+		{"Readiness", "/readyz", "ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, tt.urlPath, nil, "")
+			if code != http.StatusOK {
+				t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+			}
+			var resp struct {
+				Status string `json:"status"`
+			}
+			decodeJSON(t, body, &resp)
+			if resp.Status != tt.wantStatus {
+				t.Errorf("got status %q; want %q", resp.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadinessDuringShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	app.shuttingDown.Store(true)
+
+	code, _, body := ts.do(t, http.MethodGet, "/readyz", nil, "")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d; want %d (body %s)", code, http.StatusServiceUnavailable, body)
+	}
+	var resp struct {
+		Checks map[string]string `json:"checks"`
+	}
+	decodeJSON(t, body, &resp)
+	if resp.Checks["shutdown"] != "shutting down" {
+		t.Errorf("got shutdown check %q; want %q", resp.Checks["shutdown"], "shutting down")
+	}
+
+	// Liveness doesn't depend on shutdown.
+	if code, _, _ := ts.do(t, http.MethodGet, "/livez", nil, ""); code != http.StatusOK {
+		t.Errorf("got liveness status %d; want %d", code, http.StatusOK)
+	}
+}
+
+func TestReadinessHidesErrors(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.pictureDir = filepath.Join(t.TempDir(), "missing")
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodGet, "/readyz", nil, "")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d; want %d (body %s)", code, http.StatusServiceUnavailable, body)
+	}
+	var resp struct {
+		Checks map[string]string `json:"checks"`
+	}
+	decodeJSON(t, body, &resp)
+	if resp.Checks["storage"] != "unavailable" {
+		t.Errorf("got storage check %q; want %q", resp.Checks["storage"], "unavailable")
+	}
+	if strings.Contains(string(body), app.config.pictureDir) {
+		t.Errorf("response %s gives away the picture directory", body)
+	}
+}
+
+func TestProbesSkipRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	cfg := app.config
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.001
+	cfg.limiter.burst = 1
+	app.runtime.Store(newRuntimeConfig(cfg))
+	ts := newTestServer(t, app.routes())
+
+	for i := 0; i < 3; i++ {
+		for _, urlPath := range []string{"/livez", "/readyz"} {
+			if code, _, body := ts.do(t, http.MethodGet, urlPath, nil, ""); code != http.StatusOK {
+				t.Fatalf("got status %d for %s; want %d (body %s)", code, urlPath, http.StatusOK, body)
+			}
+		}
+	}
+
+	// Everything else is still limited.
+	ts.do(t, http.MethodGet, "/v1/healthcheck", nil, "")
+	if code, _, _ := ts.do(t, http.MethodGet, "/v1/healthcheck", nil, ""); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d for the healthcheck over the limit; want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestReadinessCachesStorageCheck(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	if code, _, body := ts.do(t, http.MethodGet, "/readyz", nil, ""); code != http.StatusOK {
+		t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+	}
+
+	// The directory going away isn't noticed until the result has expired.
+	app.config.pictureDir = filepath.Join(t.TempDir(), "missing")
+	if code, _, body := ts.do(t, http.MethodGet, "/readyz", nil, ""); code != http.StatusOK {
+		t.Fatalf("got status %d straight after a check; want %d (body %s)", code, http.StatusOK, body)
+	}
+	app.storageCheck.checked = time.Now().Add(-storageCheckInterval)
+	if code, _, body := ts.do(t, http.MethodGet, "/readyz", nil, ""); code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d once the check expired; want %d (body %s)", code, http.StatusServiceUnavailable, body)
+	}
+}
@@ -5,35 +5,123 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/i18n"
 	"interview_assignment.mohamednaas.net/internal/validator"
 )
 
-// Retrieve the "email" URL parameter from the current request context.
+// Retrieve the "user" URL parameter from the current request context, returning the ID
+// of the user it refers to: "me" is the authenticated user, anything else must be a
+// user ID. The email addresses accepted by the older routes are redirected by
+// requireUserOrAdmin() before the handlers see them.
+func (app *application) readUserParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	param := params.ByName("user")
+	if param == "me" {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			return 0, i18n.NewError("request.me_unauthenticated")
+		}
+		return user.ID, nil
+	}
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, i18n.NewError("request.invalid_user_parameter")
+	}
+	return int(id), nil
+}
+
+// Retrieve an email address given in place of a user ID in the "user" URL parameter.
 func (app *application) readEmailParam(r *http.Request) (string, error) {
 	params := httprouter.ParamsFromContext(r.Context())
-	email := params.ByName("email")
+	email := params.ByName("user")
 	if !validator.Matches(email, validator.EmailRX) {
-		return "", errors.New("invalid email parameter")
+		return "", i18n.NewError("request.invalid_email_parameter")
 	}
 	return email, nil
 }
 
+// errInvalidIDParam is returned by readIDParam() for an "id" parameter which isn't a
+// positive integer. It is translated like any other i18n error.
+var errInvalidIDParam = i18n.NewError("request.invalid_id_parameter")
+
 // Retrieve the "ID"  parameter from the current request context.
 func (app *application) readIDParam(r *http.Request) (int, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errInvalidIDParam
+	}
+	return int(id), nil
+}
+
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
 	if err != nil {
-		return 0, err
+		v.AddError(key, "validation.integer")
+		return defaultValue
+	}
+	return i
+}
+
+// readFilters reads the page and page_size query string parameters used by the list
+// endpoints, recording any problems with them in v.
+func (app *application) readFilters(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", 20, v),
 	}
-	if id <= 0 {
-		return 0, errors.New("invalid id parameter")
+	data.ValidateFilters(v, filters)
+	return filters
+}
+
+// pictureURL turns the file name of a profile picture into the URL it's served from
+// by the /static/ route.
+func (app *application) pictureURL(r *http.Request, picture string) string {
+	return fmt.Sprintf("http://%s/static/%s", r.Host, picture)
+}
+
+// The etag() helper returns the entity tag for a record at the given version, which is
+// sent in the ETag header and compared with If-Match by ifMatch().
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// The ifMatch() helper reports whether a request which changes a record at the given
+// version may go ahead: either there's no If-Match header, or it lists "*" or the
+// record's current entity tag. Weak tags (W/"...") never match, as If-Match uses the
+// strong comparison.
+func (app *application) ifMatch(r *http.Request, version int) bool {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return true
 	}
-	return int(id), nil
+	current := etag(version)
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || tag == current {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type envelope map[string]any
@@ -77,28 +165,28 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		var maxBytesError *http.MaxBytesError
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return i18n.NewError("request.bad_json_at", syntaxError.Offset)
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return i18n.NewError("request.bad_json")
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return i18n.NewError("request.wrong_json_field_type", unmarshalTypeError.Field)
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+			return i18n.NewError("request.wrong_json_type", unmarshalTypeError.Offset)
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return i18n.NewError("request.empty_body")
 		// If the JSON contains a field which cannot be mapped to the target destination
 		// then Decode() will now return an error message in the format "json: unknown
 		// field "<name>"". We check for this, extract the field name from the error,
 		// and interpolate it into our custom error message.
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+			return i18n.NewError("request.unknown_key", fieldName)
 		// Use the errors.As() function to check whether the error has the type
 		// *http.MaxBytesError. If it does, then it means the request body exceeded our
 		// size limit of 1MB and we return a clear error message.
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return i18n.NewError("request.body_too_large", maxBytesError.Limit)
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 		default:
@@ -111,8 +199,17 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	// additional data in the request body and we return our own custom error message.
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return i18n.NewError("request.multiple_json_values")
 	}
 	return nil
 
 }
+
+// clientIP returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
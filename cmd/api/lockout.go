@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+// Failed logins are counted per account (by the email address given, whether or not an
+// account has it) and per client IP, in the database so that every instance of the API
+// shares the counts. After each failure the account or client has to wait before trying
+// again, the wait doubling with each failure until it reaches the lockout duration when
+// the maximum number of failures is reached: with the defaults of 10 failures and 15
+// minutes, an account waits about 2 seconds after its first failure, 28 after its fifth
+// and 15 minutes after its tenth. Failures are forgotten once a lockout duration has
+// passed since the last one, or for an account, as soon as it logs in.
+//
+// Each attempt is counted as a failure before the password is checked, and given back
+// if it turns out not to be one. Otherwise concurrent attempts would all be let through
+// before any of them had failed, and the waits could be sidestepped by guessing in
+// parallel.
+
+// loginAttempt is an attempt to prove who the user is, with a password or a two-factor
+// code, which reserveLoginAttempt() has counted as a failure in advance. It must be
+// settled by exactly one of loginFailed(), loginSucceeded() or releaseLoginAttempt().
+type loginAttempt struct {
+	email string
+	// account and client are the counts including this attempt, and reserved is
+	// whether they were recorded, which they aren't when lockouts are disabled.
+	account, client data.LoginFailure
+	reserved        bool
+	settled         bool
+}
+
+// The loginRetryAt() method returns the time from which another login may be attempted
+// after the given failures, max being the number of failures which locks the account
+// or client out.
+func (app *application) loginRetryAt(failure data.LoginFailure, max int) time.Time {
+	if failure.Failures == 0 {
+		return time.Time{}
+	}
+	lockout := app.config.login.lockoutDuration
+	wait := time.Duration(float64(lockout) / math.Pow(2, float64(max-failure.Failures)))
+	return failure.LastFailure.Add(min(wait, lockout))
+}
+
+// The reserveLoginAttempt() method counts an attempt to log in to the account with the
+// given email against the account and the client. If either of them has to wait before
+// trying again, the attempt isn't counted and the wait is returned instead.
+func (app *application) reserveLoginAttempt(r *http.Request, email string) (*loginAttempt, time.Duration, error) {
+	attempt := &loginAttempt{email: email}
+	if !app.config.login.lockoutEnabled {
+		return attempt, 0, nil
+	}
+	cfg := app.config.login
+	now := time.Now()
+	since := now.Add(-cfg.lockoutDuration)
+	accountKey, clientKey := data.AccountLoginKey(email), data.ClientLoginKey(clientIP(r))
+
+	// Turn away attempts which have to wait without counting them.
+	account, err := app.models.LoginFailures.LoginFailureGet(r.Context(), accountKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	client, err := app.models.LoginFailures.LoginFailureGet(r.Context(), clientKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	retryAt := later(app.loginRetryAt(account, cfg.maxAccountFailures), app.loginRetryAt(client, cfg.maxClientFailures))
+	if wait := time.Until(retryAt); wait > 0 {
+		return nil, wait, nil
+	}
+
+	// Count the attempt. The counts returned include any attempts made since the ones
+	// read above, which have to be waited for as if they had already failed; only then
+	// is it safe to go on.
+	attempt.account, err = app.models.LoginFailures.LoginFailureRecord(r.Context(), accountKey, now, since)
+	if err != nil {
+		return nil, 0, err
+	}
+	attempt.reserved = true
+	attempt.client, err = app.models.LoginFailures.LoginFailureRecord(r.Context(), clientKey, now, since)
+	if err != nil {
+		app.releaseLoginAttempt(r, attempt)
+		return nil, 0, err
+	}
+
+	retryAt = later(
+		app.loginRetryAt(earlier(attempt.account, account, since, now), cfg.maxAccountFailures),
+		app.loginRetryAt(earlier(attempt.client, client, since, now), cfg.maxClientFailures),
+	)
+	if retryAt.After(now) {
+		app.releaseLoginAttempt(r, attempt)
+		return nil, retryAt.Sub(now), nil
+	}
+	return attempt, 0, nil
+}
+
+// earlier returns the failures made before a reserved attempt: one fewer than its
+// count, as of the last failure read before it was reserved. If there weren't any
+// failures then, or they had been forgotten, those before it were all made just now.
+func earlier(reserved, read data.LoginFailure, since, now time.Time) data.LoginFailure {
+	failure := data.LoginFailure{Key: reserved.Key, Failures: reserved.Failures - 1, LastFailure: read.LastFailure}
+	if read.Failures == 0 || read.LastFailure.Before(since) {
+		failure.LastFailure = now
+	}
+	return failure
+}
+
+// later returns the later of two times.
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// The loginFailed() method keeps the attempt's failure, and records it in the audit
+// trail along with any lockout it causes. reason says what was wrong, e.g.
+// "unknown_email" or "wrong_password", which is only recorded in the audit trail; the
+// client is told the same thing either way.
+func (app *application) loginFailed(r *http.Request, attempt *loginAttempt, reason string) {
+	attempt.settled = true
+	app.audit(r, auditLoginFailed, "email", attempt.email, "reason", reason)
+	if !attempt.reserved {
+		return
+	}
+	cfg := app.config.login
+	until := time.Now().Add(cfg.lockoutDuration)
+	if attempt.account.Failures == cfg.maxAccountFailures {
+		app.audit(r, auditAccountLocked, "email", attempt.email, "failures", attempt.account.Failures, "until", until)
+	}
+	if attempt.client.Failures == cfg.maxClientFailures {
+		app.audit(r, auditClientLocked, "ip", clientIP(r), "failures", attempt.client.Failures, "until", until)
+	}
+}
+
+// The loginSucceeded() method forgets the account's failed logins after the user has
+// logged in. The client's are kept, so that logging in to an account of their own
+// doesn't let an attacker reset their count between guesses at other accounts; only the
+// attempt itself is taken back.
+func (app *application) loginSucceeded(r *http.Request, attempt *loginAttempt) {
+	if attempt.settled {
+		return
+	}
+	attempt.settled = true
+	if !attempt.reserved {
+		return
+	}
+	err := app.models.LoginFailures.LoginFailureDelete(r.Context(), data.AccountLoginKey(attempt.email))
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logError(r, fmt.Errorf("clearing login failures: %w", err))
+	}
+	err = app.models.LoginFailures.LoginFailureRelease(r.Context(), attempt.client.Key)
+	if err != nil {
+		app.logError(r, fmt.Errorf("releasing login attempt: %w", err))
+	}
+}
+
+// The releaseLoginAttempt() method takes back an attempt which turned out not to be a
+// failure, such as a right password which still needs a two-factor code, or one cut
+// short by an error. It does nothing once the attempt is settled, so handlers can defer
+// it.
+func (app *application) releaseLoginAttempt(r *http.Request, attempt *loginAttempt) {
+	if attempt.settled {
+		return
+	}
+	attempt.settled = true
+	if !attempt.reserved {
+		return
+	}
+	for _, key := range []string{attempt.account.Key, attempt.client.Key} {
+		if key == "" {
+			continue
+		}
+		if err := app.models.LoginFailures.LoginFailureRelease(r.Context(), key); err != nil {
+			app.logError(r, fmt.Errorf("releasing login attempt: %w", err))
+		}
+	}
+}
+
+// The loginLockedResponse() method is sent when a login is attempted before the wait
+// after previous failures is over, with the number of seconds left in Retry-After.
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := app.translate(r, "error.login_locked", seconds)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "login_locked", message)
+}
+
+// unlockUserHandler forgets the failed logins for a user's account, lifting any lockout.
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user, err := app.models.Users.UserGetID(r.Context(), int64(id))
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	// An account without failed logins has nothing to unlock, which isn't an error.
+	err = app.models.LoginFailures.LoginFailureDelete(r.Context(), data.AccountLoginKey(user.Email))
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err == nil {
+		app.audit(r, auditLoginUnlocked, "email", user.Email)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
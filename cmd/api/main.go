@@ -3,104 +3,192 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/password"
+	_ "modernc.org/sqlite"
 )
 
 // App version
 const version = "1.0"
 
-type config struct {
-	port       int
-	env        string
-	pictureDir string
-	db         struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
+type application struct {
+	config  config
+	logger  *slog.Logger
+	metrics *metrics
+	db      *sql.DB
+	models  data.Models
+	// passwordPolicy is the policy new passwords are checked against, and
+	// passwordHasher the hasher the models hash them with.
+	passwordPolicy password.Policy
+	passwordHasher password.Hasher
+	// wg tracks the goroutines started with background(), and done is closed to tell
+	// them to stop when the server shuts down.
+	wg   sync.WaitGroup
+	done chan struct{}
+	// shuttingDown is set as soon as a shutdown signal is received, and causes the
+	// readiness probe to fail.
+	shuttingDown atomic.Bool
+	// storageCheck caches the readiness probe's storage check; see checkStorage().
+	storageCheck storageCheck
+	// logLevel and runtime hold the settings which can be changed by reloading the
+	// configuration; see reloadConfig(). activeConfig is the startup configuration
+	// with the reloaded settings applied, and is only accessed with reloadMu held.
+	logLevel     *slog.LevelVar
+	runtime      atomic.Pointer[runtimeConfig]
+	configArgs   []string
+	reloadMu     sync.Mutex
+	activeConfig config
+	lastReload   atomic.Pointer[reloadResult]
+}
+
+func main() {
+	// get sytem configuration variables from the config file, environment and flags
+	cfg, command, printOnly, err := loadConfig(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
-	jwt struct {
-		secret string // Add a new field to store the JWT signing secret.
+	if printOnly {
+		os.Exit(0)
 	}
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+	if len(command) > 0 && command[0] != "migrate" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command[0])
+		os.Exit(2)
 	}
-}
 
-type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
-}
+	// Create a structured logger which writes JSON log entries to stdout. The minimum
+	// level is taken from the log-level setting, and can be changed by reloading the
+	// configuration, so debug output can be enabled without a restart.
+	level := new(slog.LevelVar)
+	level.UnmarshalText([]byte(cfg.logLevel))
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 
-func main() {
-	// get sytem configuration variables
-	var cfg config
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("sainpr"), "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
-	flag.StringVar(&cfg.pictureDir, "pfpicture directory", os.Getenv("sainpr_pfp_dir"), "Directory where users profile images are sotred")
-	flag.StringVar(&cfg.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "JWT signing secret")
-
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	flag.Parse()
-
-	// Create message and error logger
-
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	app := &application{
-		config: cfg,
-		logger: logger,
+		config:       cfg,
+		logger:       logger,
+		done:         make(chan struct{}),
+		logLevel:     level,
+		configArgs:   os.Args[1:],
+		activeConfig: cfg,
 	}
+	app.runtime.Store(newRuntimeConfig(cfg))
 
-	// Establish DB connection pool
-	db, err := openDB(cfg)
+	// Set up tracing before opening the database, so that the instrumented driver
+	// picks up the configured tracer provider.
+	shutdownTracing, err := setupTracing(cfg)
 	if err != nil {
-		logger.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	logger.Printf("database connection pool established")
+	// Establish DB connection pool. The scheme of the DSN selects PostgreSQL or SQLite.
+	source, err := data.ParseDSN(cfg.db.dsn)
+	if err != nil {
+		logger.Error(err.Error())
+		shutdownTracing(context.Background())
+		os.Exit(1)
+	}
+	db, err := openDB(cfg, source)
+	if err != nil {
+		logger.Error(err.Error())
+		shutdownTracing(context.Background())
+		os.Exit(1)
+	}
+
+	logger.Info("database connection pool established", "database", source.Dialect)
 
 	defer db.Close()
 
+	// Run the migrate subcommand instead of the server if it was given.
+	if len(command) > 0 {
+		err := runMigrateCommand(context.Background(), db, source.Dialect, command[1:], os.Stdout)
+		if err != nil {
+			logger.Error(err.Error())
+			db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Bring the schema up to date before serving, if enabled. The migrations hold an
+	// advisory lock on PostgreSQL, so replicas starting together apply them only once.
+	if cfg.db.migrate {
+		err := app.migrateUp(context.Background(), db, source.Dialect)
+		if err != nil {
+			logger.Error(err.Error())
+			db.Close()
+			os.Exit(1)
+		}
+	}
+
+	// The password settings are only needed by the server, and so aren't validated for
+	// the migrate command.
+	app.passwordHasher = newPasswordHasher(cfg)
+	app.passwordPolicy, err = newPasswordPolicy(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		db.Close()
+		os.Exit(1)
+	}
+
 	// Setup Models used to interact with the Database
-	app.models = data.NewModels(db)
+	app.db = db
+	app.models = data.NewModels(db, data.Timeouts{
+		Default:    cfg.db.timeout,
+		Operations: cfg.db.operationTimeouts,
+	}, app.passwordHasher)
 
-	// Set up server
-	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	// Setup the Prometheus collectors, including the connection pool statistics.
+	app.metrics = newMetrics(db, source.Dialect)
+
+	// Start serving requests. serve() only returns once the server has been shut down.
+	serveErr := app.serve()
+
+	// Flush any spans which are still buffered before exiting.
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error(err.Error())
 	}
-	logger.Printf("starting %s server on %s", cfg.env, srv.Addr)
-	err = srv.ListenAndServe()
-	logger.Fatal(err)
 
+	if serveErr != nil {
+		logger.Error(serveErr.Error())
+		db.Close()
+		os.Exit(1)
+	}
 }
 
 // The openDB() function returns a sql.DB connection pool.
-func openDB(cfg config) (*sql.DB, error) {
-	// Use sql.Open() to create an empty connection pool, using the DSN from the config
-	// struct.
-	db, err := sql.Open("postgres", cfg.db.dsn)
+func openDB(cfg config, source data.DataSource) (*sql.DB, error) {
+	system := semconv.DBSystemPostgreSQL
+	if source.Dialect == data.SQLite {
+		system = semconv.DBSystemSqlite
+	}
+
+	// Use otelsql.Open() to create an empty connection pool, using the driver and DSN
+	// selected by the configured DSN. This wraps the driver so that every statement
+	// executed through the pool is recorded as a span under the context passed to it.
+	db, err := otelsql.Open(source.Driver, source.DSN,
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -131,3 +219,42 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Return the sql.DB connection pool.
 	return db, nil
 }
+
+// newPasswordPolicy builds the policy for new passwords from the configuration, loading
+// the breached password filter if one was given in place of the bundled one.
+func newPasswordPolicy(cfg config) (password.Policy, error) {
+	policy := password.DefaultPolicy()
+	policy.MinLength = cfg.password.minLength
+	policy.MaxBytes = cfg.password.maxBytes
+	policy.MinClasses = cfg.password.minClasses
+	policy.ForbidPersonal = cfg.password.forbidPersonal
+	policy.MinScore = cfg.password.minScore
+
+	switch {
+	case !cfg.password.breachedCheck:
+		policy.Breached = nil
+	case cfg.password.breachedFilter != "":
+		filter, err := password.LoadFilter(cfg.password.breachedFilter)
+		if err != nil {
+			return policy, fmt.Errorf("password-breached-filter: %w", err)
+		}
+		policy.Breached = filter
+	}
+	return policy, nil
+}
+
+// newPasswordHasher returns the hasher for new passwords described by the configuration.
+// Existing hashes made with other settings are replaced as their users log in.
+func newPasswordHasher(cfg config) password.Hasher {
+	return password.Hasher{
+		Algorithm: cfg.password.hashAlgorithm,
+		Argon2: password.Argon2Params{
+			Memory:      uint32(cfg.password.argon2Memory),
+			Iterations:  uint32(cfg.password.argon2Iterations),
+			Parallelism: uint8(cfg.password.argon2Parallelism),
+			SaltLength:  password.DefaultHasher().Argon2.SaltLength,
+			KeyLength:   password.DefaultHasher().Argon2.KeyLength,
+		},
+		BcryptCost: cfg.password.bcryptCost,
+	}
+}
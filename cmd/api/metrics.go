@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+// The metrics struct holds the Prometheus collectors for the application. We use our
+// own registry rather than the global default one, so that the collectors are only
+// registered once per application instance.
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	rateLimited     prometheus.Counter
+	authFailures    *prometheus.CounterVec
+	configReloads   *prometheus.CounterVec
+	lastReload      *prometheus.GaugeVec
+}
+
+// newMetrics creates and registers the application collectors. If db is not nil, the
+// connection pool statistics from db.Stats() are exported as well, labelled with the
+// dialect of the database.
+func newMetrics(db *sql.DB, dialect data.Dialect) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests processed, by route pattern and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to process HTTP requests, by route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_rate_limited_requests_total",
+			Help: "Total number of requests rejected by the rate limiter.",
+		}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "authentication_failures_total",
+			Help: "Total number of rejected authentication tokens, by reason.",
+		}, []string{"reason"}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Total number of configuration reloads, by result.",
+		}, []string{"result"}),
+		lastReload: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "config_last_reload_timestamp_seconds",
+			Help: "Unix time of the last configuration reload, by result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.rateLimited,
+		m.authFailures,
+		m.configReloads,
+		m.lastReload,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, string(dialect)))
+	}
+
+	return m
+}
+
+// observeRequest records the outcome of a single request. Requests which didn't match
+// any route are grouped under one label, so that scanners probing random URLs can't
+// blow up the number of time series.
+func (m *metrics) observeRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// observeReload records the outcome of a configuration reload.
+func (m *metrics) observeReload(success bool, at time.Time) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	m.configReloads.WithLabelValues(result).Inc()
+	m.lastReload.WithLabelValues(result).Set(float64(at.Unix()))
+}
+
+// handler returns an http.Handler which serves the registered metrics in the
+// Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
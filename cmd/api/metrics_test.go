@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+func TestDBStatsMetricsDialect(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	families, err := newMetrics(db, data.SQLite).registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() != "go_sql_open_connections" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "db_name" {
+					found = true
+					if label.GetValue() != "sqlite" {
+						t.Errorf("got db_name %q; want %q", label.GetValue(), "sqlite")
+					}
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("got no connection pool metrics labelled with db_name")
+	}
+}
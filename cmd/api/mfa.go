@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/totp"
+	"interview_assignment.mohamednaas.net/internal/validator"
+)
+
+// Two-factor authentication is optional: a user enrols by fetching a new TOTP secret,
+// adding it to their authenticator app, and confirming it with a code, which also hands
+// out their recovery codes. From then on the password alone only earns a challenge
+// token, which is exchanged for an authentication token at /v1/tokens/mfa along with
+// either the current code or one of the recovery codes. With mfa-require-admin set,
+// admins can't use their admin rights until they have enrolled.
+
+const (
+	// mfaAudience is the audience of challenge tokens, which the authenticate
+	// middleware doesn't accept, so that they can't be used in place of the real thing.
+	mfaAudience = tokenIssuer + "/mfa"
+	// recoveryCodeCount is the number of recovery codes handed out on enrolment.
+	recoveryCodeCount = 10
+	// totpSkew is the number of 30 second periods either side of the current one whose
+	// codes are also accepted, to allow for clocks which are a little out.
+	totpSkew = 1
+)
+
+// errWrongCode is returned from a transaction when the code it was given isn't valid,
+// or has already been used.
+var errWrongCode = errors.New("wrong code")
+
+// The mfaEnabled() method reports whether the user has confirmed two-factor
+// authentication, and so has to give a code to log in.
+func (app *application) mfaEnabled(r *http.Request, userID int) (bool, error) {
+	t, err := app.models.TOTP.TOTPGet(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return t.Confirmed, nil
+}
+
+// The mfaMissing() method reports whether the policy requires the user to enable
+// two-factor authentication before using admin rights, and they haven't. If the check
+// fails it errs on the side of refusing the rights.
+func (app *application) mfaMissing(r *http.Request, userID int) bool {
+	if !app.config.mfa.requireAdmin {
+		return false
+	}
+	enabled, err := app.mfaEnabled(r, userID)
+	if err != nil {
+		app.logError(r, fmt.Errorf("checking two-factor authentication: %w", err))
+		return true
+	}
+	return !enabled
+}
+
+// The hasAdminRights() method reports whether the user is an admin who may use their
+// admin rights under the two-factor authentication policy.
+func (app *application) hasAdminRights(r *http.Request, userID int) (bool, error) {
+	isAdmin, err := app.models.Users.IsAdmin(r.Context(), userID)
+	if err != nil || !isAdmin {
+		return false, err
+	}
+	return !app.mfaMissing(r, userID), nil
+}
+
+// The checkSecondFactor() method reports whether code is the user's current TOTP code,
+// or if recoveryCode is given instead, whether it's one of their unused recovery codes,
+// which is then used up. Each TOTP code is only accepted once.
+func (app *application) checkSecondFactor(r *http.Request, t data.TOTP, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		ok, err := app.models.TOTP.RecoveryCodeUse(r.Context(), t.UserID, totp.HashRecoveryCode(recoveryCode))
+		if err != nil || !ok {
+			return false, err
+		}
+		left, err := app.models.TOTP.RecoveryCodesCount(r.Context(), t.UserID)
+		if err != nil {
+			return false, err
+		}
+		app.audit(r, auditRecoveryCodeUsed, "user_id", t.UserID, "codes_left", left)
+		return true, nil
+	}
+
+	counter, ok := totp.Validate(t.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+	return app.models.TOTP.TOTPUseCounter(r.Context(), t.UserID, counter)
+}
+
+// createMFATokenHandler is the second step of a two-factor login. It exchanges the
+// challenge token from createAuthenticationTokenHandler and a TOTP or recovery code for
+// an authentication token.
+func (app *application) createMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.MFAToken != "", "mfa_token", "validation.required")
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "validation.code.required")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// The challenge token is checked like an authentication token, but for its own
+	// audience. An expired one means starting again with the password.
+	claims, err := jwt.HMACCheck([]byte(input.MFAToken), []byte(app.config.jwt.secret))
+	if err != nil || !claims.Valid(time.Now()) || claims.Issuer != tokenIssuer || !claims.AcceptAudience(mfaAudience) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.UserGetID(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Wrong codes count as failed logins, so guessing them is slowed down and locked
+	// out just like guessing passwords.
+	attempt, wait, err := app.reserveLoginAttempt(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.audit(r, auditLoginBlocked, "email", user.Email, "retry_after", wait.Round(time.Second).String())
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+	defer app.releaseLoginAttempt(r, attempt)
+
+	t, err := app.models.TOTP.TOTPGet(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !t.Confirmed {
+		// Two-factor authentication was turned off since the challenge was issued.
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(r, t, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		reason := "wrong_mfa_code"
+		if input.RecoveryCode != "" {
+			reason = "wrong_recovery_code"
+		}
+		app.loginFailed(r, attempt, reason)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.loginSucceeded(r, attempt)
+	app.authenticationTokenResponse(w, r, user.ID)
+}
+
+// enrolTOTPHandler starts enrolment in two-factor authentication by generating a new
+// secret for the user, returned along with the otpauth:// URI authenticator apps read
+// from a QR code. It replaces any earlier secret which wasn't confirmed. Only the user
+// themselves may enrol, since they have to have the authenticator.
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if id != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// A confirmed secret has to be deleted before enrolling again, which TOTPSet
+	// reports as a conflict.
+	err = app.models.TOTP.TOTPSet(r.Context(), user.ID, secret)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	body := envelope{"totp": envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(app.config.mfa.issuer, user.Email, secret),
+	}}
+	err = app.writeJSON(w, http.StatusCreated, body, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler finishes enrolment with a code from the authenticator, which
+// proves it holds the secret. From then on the code is asked for at login. The response
+// holds the user's recovery codes, which are only stored hashed and so can't be shown
+// again.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if id != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	t, err := app.models.TOTP.TOTPGet(r.Context(), user.ID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	if t.Confirmed {
+		app.dataErrorResponse(w, r, data.ErrConflict)
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	hashes := make([][]byte, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		counter, valid := totp.Validate(t.Secret, input.Code, time.Now(), totpSkew)
+		if valid {
+			unused, err := tx.TOTP.TOTPUseCounter(r.Context(), user.ID, counter)
+			if err != nil {
+				return err
+			}
+			valid = unused
+		}
+		if !valid {
+			return errWrongCode
+		}
+		if err := tx.TOTP.TOTPConfirm(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return tx.TOTP.RecoveryCodesReplace(r.Context(), user.ID, hashes)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errWrongCode):
+			v := validator.New()
+			v.AddError("code", "validation.code.wrong")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.audit(r, auditMFAEnabled, "user_id", user.ID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler turns two-factor authentication off. Users turning off their own
+// must send a current code or a recovery code, so that a stolen token isn't enough;
+// admins may turn off anyone else's, e.g. for a user who has lost their authenticator
+// and their recovery codes.
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	t, err := app.models.TOTP.TOTPGet(r.Context(), id)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	if user := app.contextGetUser(r); id == user.ID {
+		var input struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// An unconfirmed secret isn't in use yet, so it can go without a code. Wrong codes
+		// count as failed logins, as they do when logging in, so that a stolen token can't
+		// be used to guess them any faster.
+		if t.Confirmed {
+			attempt, wait, err := app.reserveLoginAttempt(r, user.Email)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if wait > 0 {
+				app.audit(r, auditLoginBlocked, "email", user.Email, "retry_after", wait.Round(time.Second).String())
+				app.loginLockedResponse(w, r, wait)
+				return
+			}
+			defer app.releaseLoginAttempt(r, attempt)
+
+			ok, err := app.checkSecondFactor(r, t, input.Code, input.RecoveryCode)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if !ok {
+				reason := "wrong_mfa_code"
+				if input.RecoveryCode != "" {
+					reason = "wrong_recovery_code"
+				}
+				app.loginFailed(r, attempt, reason)
+				v := validator.New()
+				v.AddError("code", "validation.code.wrong")
+				app.failedValidationResponse(w, r, v)
+				return
+			}
+		}
+	}
+
+	err = app.models.TOTP.TOTPDelete(r.Context(), id)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	app.audit(r, auditMFADisabled, "user_id", id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The mfaRequiredResponse() method is sent when an admin who hasn't enabled two-factor
+// authentication tries to use their admin rights while the policy requires it.
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "error.mfa_required")
+	app.errorResponse(w, r, http.StatusForbidden, "mfa_required", message)
+}
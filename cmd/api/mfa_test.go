@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/totp"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, token := createTestUser(t, app, "Alice", "alice@example.com", false)
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	// Codes are made relative to one counter, so that the test doesn't depend on which
+	// side of a period boundary each request falls.
+	now := totp.Counter(time.Now())
+	code := func(secret string, offset int64) string {
+		c, err := totp.Code(secret, now+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// Enrolment returns a secret and the URI for it, and only the user may enrol.
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/users/1/totp", nil, adminToken); status != http.StatusForbidden {
+		t.Errorf("got status %d enrolling someone else; want %d (body %s)", status, http.StatusForbidden, body)
+	}
+	status, _, body := ts.do(t, http.MethodPost, "/v1/users/me/totp", nil, token)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d enrolling; want %d (body %s)", status, http.StatusCreated, body)
+	}
+	var enrolment struct {
+		TOTP struct {
+			Secret string `json:"secret"`
+			URI    string `json:"otpauth_uri"`
+		} `json:"totp"`
+	}
+	decodeJSON(t, body, &enrolment)
+	secret := enrolment.TOTP.Secret
+	if u, err := url.Parse(enrolment.TOTP.URI); err != nil || u.Query().Get("secret") != secret {
+		t.Errorf("got URI %q; want one for secret %q", enrolment.TOTP.URI, secret)
+	}
+
+	// Until it's confirmed, logging in only takes the password.
+	login := map[string]string{"email": "alice@example.com", "password": "pa55word1234"}
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", login, ""); status != http.StatusCreated {
+		t.Errorf("got status %d logging in before confirming; want %d (body %s)", status, http.StatusCreated, body)
+	}
+
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/users/me/totp/confirm", map[string]string{"code": "000000"}, token); status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d confirming with a wrong code; want %d (body %s)", status, http.StatusUnprocessableEntity, body)
+	}
+	status, _, body = ts.do(t, http.MethodPost, "/v1/users/me/totp/confirm", map[string]string{"code": code(secret, 0)}, token)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d confirming; want %d (body %s)", status, http.StatusOK, body)
+	}
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeJSON(t, body, &confirmation)
+	if len(confirmation.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(confirmation.RecoveryCodes), recoveryCodeCount)
+	}
+	if status, _, _ := ts.do(t, http.MethodPost, "/v1/users/me/totp", nil, token); status != http.StatusConflict {
+		t.Errorf("got status %d enrolling again; want %d", status, http.StatusConflict)
+	}
+
+	// Now the password only earns a challenge token, which isn't an authentication token.
+	challenge := func() string {
+		t.Helper()
+		status, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", login, "")
+		if status != http.StatusAccepted {
+			t.Fatalf("got status %d logging in; want %d (body %s)", status, http.StatusAccepted, body)
+		}
+		var resp struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}
+		decodeJSON(t, body, &resp)
+		if !resp.MFARequired || resp.MFAToken == "" {
+			t.Fatalf("got %s; want a challenge token", body)
+		}
+		return resp.MFAToken
+	}
+	mfaToken := challenge()
+	if status, _, _ := ts.do(t, http.MethodGet, "/v1/users/me", nil, mfaToken); status != http.StatusUnauthorized {
+		t.Errorf("got status %d using a challenge token; want %d", status, http.StatusUnauthorized)
+	}
+
+	tests := []struct {
+		name     string
+		body     map[string]string
+		wantCode int
+	}{
+		{"No code", map[string]string{"mfa_token": mfaToken}, http.StatusUnprocessableEntity},
+		{"Wrong code", map[string]string{"mfa_token": mfaToken, "code": "000000"}, http.StatusUnauthorized},
+		{"Authentication token", map[string]string{"mfa_token": token, "code": code(secret, 1)}, http.StatusUnauthorized},
+		// The code used to confirm has been used up.
+		{"Replayed code", map[string]string{"mfa_token": mfaToken, "code": code(secret, 0)}, http.StatusUnauthorized},
+		{"Valid code", map[string]string{"mfa_token": mfaToken, "code": code(secret, 1)}, http.StatusCreated},
+		{"Valid code again", map[string]string{"mfa_token": mfaToken, "code": code(secret, 1)}, http.StatusUnauthorized},
+		{"Recovery code", map[string]string{"mfa_token": mfaToken, "recovery_code": confirmation.RecoveryCodes[0]}, http.StatusCreated},
+		{"Recovery code again", map[string]string{"mfa_token": mfaToken, "recovery_code": confirmation.RecoveryCodes[0]}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, body := ts.do(t, http.MethodPost, "/v1/tokens/mfa", tt.body, "")
+			if status != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", status, tt.wantCode, body)
+			}
+			if status != http.StatusCreated {
+				return
+			}
+			var resp struct {
+				Token string `json:"authentication_token"`
+			}
+			decodeJSON(t, body, &resp)
+			if status, _, body := ts.do(t, http.MethodGet, "/v1/users/me", nil, resp.Token); status != http.StatusOK {
+				t.Errorf("got status %d using the new token; want %d (body %s)", status, http.StatusOK, body)
+			}
+		})
+	}
+
+	// Turning it off takes a code, after which the password is enough again.
+	if status, _, body := ts.do(t, http.MethodDelete, "/v1/users/me/totp", map[string]string{"code": "000000"}, token); status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d disabling with a wrong code; want %d (body %s)", status, http.StatusUnprocessableEntity, body)
+	}
+	if status, _, body := ts.do(t, http.MethodDelete, "/v1/users/me/totp", map[string]string{"recovery_code": confirmation.RecoveryCodes[1]}, token); status != http.StatusOK {
+		t.Fatalf("got status %d disabling; want %d (body %s)", status, http.StatusOK, body)
+	}
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/tokens/mfa", map[string]string{"mfa_token": mfaToken, "recovery_code": confirmation.RecoveryCodes[2]}, ""); status != http.StatusUnauthorized {
+		t.Errorf("got status %d with a challenge from before disabling; want %d (body %s)", status, http.StatusUnauthorized, body)
+	}
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", login, ""); status != http.StatusCreated {
+		t.Errorf("got status %d logging in after disabling; want %d (body %s)", status, http.StatusCreated, body)
+	}
+}
+
+func TestAdminMFAPolicy(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.mfa.requireAdmin = true
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	// Without two-factor authentication the admin can't use their rights, but can
+	// still reach their own record and enrol.
+	if status, _, body := ts.do(t, http.MethodGet, "/v1/users/2", nil, adminToken); status != http.StatusForbidden {
+		t.Errorf("got status %d reaching another user; want %d (body %s)", status, http.StatusForbidden, body)
+	}
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/categories", map[string]string{"name": "Books"}, adminToken); status != http.StatusForbidden {
+		t.Errorf("got status %d creating a category; want %d (body %s)", status, http.StatusForbidden, body)
+	}
+	if status, _, body := ts.do(t, http.MethodGet, "/v1/users/me", nil, adminToken); status != http.StatusOK {
+		t.Errorf("got status %d reaching their own record; want %d (body %s)", status, http.StatusOK, body)
+	}
+
+	status, _, body := ts.do(t, http.MethodPost, "/v1/users/me/totp", nil, adminToken)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d enrolling; want %d (body %s)", status, http.StatusCreated, body)
+	}
+	var enrolment struct {
+		TOTP struct {
+			Secret string `json:"secret"`
+		} `json:"totp"`
+	}
+	decodeJSON(t, body, &enrolment)
+	code, err := totp.Code(enrolment.TOTP.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _, body := ts.do(t, http.MethodPost, "/v1/users/me/totp/confirm", map[string]string{"code": code}, adminToken); status != http.StatusOK {
+		t.Fatalf("got status %d confirming; want %d (body %s)", status, http.StatusOK, body)
+	}
+
+	// Once enrolled, the rights are back.
+	if status, _, body := ts.do(t, http.MethodGet, "/v1/users/2", nil, adminToken); status != http.StatusOK {
+		t.Errorf("got status %d reaching another user after enrolling; want %d (body %s)", status, http.StatusOK, body)
+	}
+}
+
+func TestDisableTOTPLockout(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.login.lockoutEnabled = true
+	app.config.login.maxAccountFailures = 3
+	app.config.login.maxClientFailures = 100
+	app.config.login.lockoutDuration = time.Hour
+	ts := newTestServer(t, app.routes())
+
+	_, token := createTestUser(t, app, "Alice", "alice@example.com", false)
+	status, _, body := ts.do(t, http.MethodPost, "/v1/users/me/totp", nil, token)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d enrolling; want %d (body %s)", status, http.StatusCreated, body)
+	}
+	var enrolment struct {
+		TOTP struct {
+			Secret string `json:"secret"`
+		} `json:"totp"`
+	}
+	decodeJSON(t, body, &enrolment)
+	code, err := totp.Code(enrolment.TOTP.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _, body = ts.do(t, http.MethodPost, "/v1/users/me/totp/confirm", map[string]string{"code": code}, token)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d confirming; want %d (body %s)", status, http.StatusOK, body)
+	}
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeJSON(t, body, &confirmation)
+
+	// A wrong code counts as a failed login, so the next attempt has to wait, even with
+	// a right code.
+	if status, _, body := ts.do(t, http.MethodDelete, "/v1/users/me/totp", map[string]string{"code": "000000"}, token); status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d disabling with a wrong code; want %d (body %s)", status, http.StatusUnprocessableEntity, body)
+	}
+	status, header, body := ts.do(t, http.MethodDelete, "/v1/users/me/totp", map[string]string{"recovery_code": confirmation.RecoveryCodes[0]}, token)
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("got status %d disabling while waiting; want %d (body %s)", status, http.StatusTooManyRequests, body)
+	}
+	if header.Get("Retry-After") == "" {
+		t.Error("got no Retry-After header")
+	}
+	if _, err := app.models.TOTP.TOTPGet(context.Background(), 1); err != nil {
+		t.Errorf("getting the secret after being turned away returned %v; want it kept", err)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -15,6 +18,7 @@ import (
 
 	"interview_assignment.mohamednaas.net/internal/data"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/pascaldekloe/jwt"
 )
 
@@ -43,6 +47,189 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// The requestID() middleware assigns every request an ID which is returned to the
+// client in the X-Request-ID header and attached to every log entry for that request. If
+// the client (or a proxy in front of us) already sent a well-formed X-Request-ID, we
+// reuse it so the request can be followed across services.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = generateRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client supplied request ID is safe to reuse. We only
+// accept short values made of characters which can't be used to forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// generateRequestID returns a random 128-bit, hex encoded request ID.
+func generateRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// The loggingResponseWriter type wraps an http.ResponseWriter and records the status
+// code and number of bytes written, so they can be included in the access log.
+type loggingResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
+	return &loggingResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (lw *loggingResponseWriter) Header() http.Header {
+	return lw.wrapped.Header()
+}
+
+func (lw *loggingResponseWriter) WriteHeader(statusCode int) {
+	lw.wrapped.WriteHeader(statusCode)
+	if !lw.headerWritten {
+		lw.statusCode = statusCode
+		lw.headerWritten = true
+	}
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	lw.headerWritten = true
+	n, err := lw.wrapped.Write(b)
+	lw.bytesWritten += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, which lets http.ResponseController
+// reach the original writer (for flushing, deadlines etc.).
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.wrapped
+}
+
+// The logRequest() middleware writes an access log entry once each request has been
+// handled, recording the status, response size, latency and authenticated user. The
+// same figures are recorded in the request metrics.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &accessLogEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
+
+		lw := newLoggingResponseWriter(w)
+		next.ServeHTTP(lw, r)
+
+		duration := time.Since(start)
+		app.metrics.observeRequest(r.Method, entry.route, lw.statusCode, duration)
+
+		app.logger.Info("request completed",
+			"request_id", app.contextGetRequestID(r),
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"route", entry.route,
+			"remote_addr", r.RemoteAddr,
+			"status", lw.statusCode,
+			"bytes", lw.bytesWritten,
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"user_id", entry.userID,
+			"trace_id", entry.traceID,
+		)
+	})
+}
+
+// The recordRoute() middleware records the URL pattern a handler was registered with in
+// the access log entry for the request, and starts a span covering the handler itself.
+// It's applied to every route in routes().
+func (app *application) recordRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if entry := app.contextGetAccessLog(r); entry != nil {
+			entry.route = pattern
+		}
+		ctx, span := tracer.Start(r.Context(), "handler "+r.Method+" "+pattern)
+		defer span.End()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// The enableCORS() middleware allows cross-origin requests from the trusted origins in
+// the configuration, including handling preflight requests.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Origin header (and for preflight requests, the
+		// requested method), so caches must key on them.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			for _, trusted := range app.runtime.Load().trustedOrigins {
+				if origin != trusted {
+					continue
+				}
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				// Let scripts read the ETag, which they need to send back in If-Match.
+				w.Header().Set("Access-Control-Expose-Headers", "ETag")
+				// Treat OPTIONS requests with an Access-Control-Request-Method header as
+				// preflight requests, and answer them directly.
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match")
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The maintenanceMode() middleware rejects requests with 503 Service Unavailable while
+// maintenance mode is switched on. The health and probe endpoints keep working, so
+// that orchestrators don't restart the server.
+func (app *application) maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.runtime.Load().maintenance {
+			switch r.URL.Path {
+			case "/livez", "/readyz", "/v1/healthcheck":
+			default:
+				app.maintenanceModeResponse(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) rateLimit(next http.Handler) http.Handler {
 	// Define a client struct to hold the rate limiter and last seen time for each
 	// client.
@@ -56,10 +243,16 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		clients = make(map[string]*client)
 	)
 	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
-	go func() {
+	// every minute, until the server shuts down.
+	app.background(func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
 		for {
-			time.Sleep(time.Minute)
+			select {
+			case <-ticker.C:
+			case <-app.done:
+				return
+			}
 			// Lock the mutex to prevent any rate limiter checks from happening while
 			// the cleanup is taking place.
 			mu.Lock()
@@ -73,10 +266,12 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			// Importantly, unlock the mutex when the cleanup is complete.
 			mu.Unlock()
 		}
-	}()
+	})
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limiting is enabled.
-		if app.config.limiter.enabled {
+		// Only carry out the check if rate limiting is enabled. The limits can be
+		// changed by reloading the configuration, so read them for every request.
+		limits := app.runtime.Load().limiter
+		if limits.enabled {
 			ip, _, err := net.SplitHostPort(r.RemoteAddr)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
@@ -87,12 +282,19 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 				clients[ip] = &client{
 					// Use the requests-per-second and burst values from the config
 					// struct.
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					limiter: rate.NewLimiter(rate.Limit(limits.rps), limits.burst),
 				}
 			}
+			// Bring existing limiters in line with the configuration if it has been
+			// reloaded since they were created.
+			if l := clients[ip].limiter; l.Limit() != rate.Limit(limits.rps) || l.Burst() != limits.burst {
+				l.SetLimit(rate.Limit(limits.rps))
+				l.SetBurst(limits.burst)
+			}
 			clients[ip].lastSeen = time.Now()
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
+				app.metrics.rateLimited.Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
@@ -113,7 +315,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+			app.authenticationFailed(w, r, "malformed_header")
 			return
 		}
 		token := headerParts[1]
@@ -122,22 +324,22 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// or the algorithm isn't valid.
 		claims, err := jwt.HMACCheck([]byte(token), []byte(app.config.jwt.secret))
 		if err != nil {
-			app.invalidAuthenticationTokenResponse(w, r)
+			app.authenticationFailed(w, r, "invalid_signature")
 			return
 		}
 		// Check if the JWT is still valid at this moment in time.
 		if !claims.Valid(time.Now()) {
-			app.invalidAuthenticationTokenResponse(w, r)
+			app.authenticationFailed(w, r, "expired")
 			return
 		}
 		// Check that the issuer is our application.
-		if claims.Issuer != "interview_assignment.mohamednaas.net" {
-			app.invalidAuthenticationTokenResponse(w, r)
+		if claims.Issuer != tokenIssuer {
+			app.authenticationFailed(w, r, "invalid_issuer")
 			return
 		}
 		// Check that our application is in the expected audiences for the JWT.
-		if !claims.AcceptAudience("interview_assignment.mohamednaas.net") {
-			app.invalidAuthenticationTokenResponse(w, r)
+		if !claims.AcceptAudience(tokenIssuer) {
+			app.authenticationFailed(w, r, "invalid_audience")
 			return
 		}
 		// At this point, we know that the JWT is all OK and we can trust the data in
@@ -149,11 +351,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		// Lookup the user record from the database.
-		user, err := app.models.Users.UserGetID(userID, *r)
+		user, err := app.models.Users.UserGetID(r.Context(), userID)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				app.authenticationFailed(w, r, "unknown_user")
 			default:
 				app.serverErrorResponse(w, r, err)
 			}
@@ -165,6 +367,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// authenticationFailed records a rejected authentication token in the metrics, labelled
+// with the reason it was rejected, before sending the usual 401 response.
+func (app *application) authenticationFailed(w http.ResponseWriter, r *http.Request, reason string) {
+	app.metrics.authFailures.WithLabelValues(reason).Inc()
+	app.invalidAuthenticationTokenResponse(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -180,13 +389,87 @@ func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		// GET email parameter for same-user editing
-		email, _ := app.readEmailParam(r)
+		isAdmin, err := app.models.Users.IsAdmin(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !isAdmin {
+			app.adminAuthenticationRequiredResponse(w, r)
+			return
+		}
+		if app.mfaMissing(r, user.ID) {
+			app.mfaRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireUserOrAdmin() middleware protects the /v1/users/:user routes, which users
+// may use on themselves and admins on anyone. The check compares user IDs, so it keeps
+// working when a user changes their email. It must run after requireAuthenticatedUser().
+//
+// The routes used to be keyed by email address, which is still accepted: once the
+// caller is allowed to see the user, they are redirected to the same route keyed by ID
+// so that the email doesn't end up in any more URLs.
+func (app *application) requireUserOrAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		isAdmin, err := app.models.Users.IsAdmin(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if strings.Contains(httprouter.ParamsFromContext(r.Context()).ByName("user"), "@") {
+			app.redirectEmailRoute(w, r, user, isAdmin)
+			return
+		}
 
-		if !app.models.Users.IsAdmin(user.ID) && email != user.Email {
+		id, err := app.readUserParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		if id != user.ID && !isAdmin {
 			app.adminAuthenticationRequiredResponse(w, r)
 			return
 		}
+		if id != user.ID && app.mfaMissing(r, user.ID) {
+			app.mfaRequiredResponse(w, r)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// redirectEmailRoute sends a 308 Permanent Redirect from a user route keyed by email to
+// the same route keyed by the user's ID, which keeps the method and body. Only admins
+// may look up other users, so nobody else can find out whether an email is registered.
+func (app *application) redirectEmailRoute(w http.ResponseWriter, r *http.Request, user *data.User, isAdmin bool) {
+	email, err := app.readEmailParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	id := user.ID
+	if email != user.Email {
+		if !isAdmin {
+			app.adminAuthenticationRequiredResponse(w, r)
+			return
+		}
+		target, err := app.models.Users.UserGet(r.Context(), email)
+		if err != nil {
+			app.dataErrorResponse(w, r, err)
+			return
+		}
+		id = target.ID
+	}
+
+	location := *r.URL
+	location.Path = strings.Replace(r.URL.Path, "/"+email, "/"+strconv.Itoa(id), 1)
+	location.RawPath = ""
+	http.Redirect(w, r, location.String(), http.StatusPermanentRedirect)
+}
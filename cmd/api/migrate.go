@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/migrate"
+	"interview_assignment.mohamednaas.net/migrations"
+)
+
+const migrateUsage = `usage: api [flags] migrate <command>
+
+commands:
+  up             apply all pending migrations
+  down [N|all]   roll back the latest N migrations (default 1), or all of them
+  status         show the current schema version and pending migrations
+  force V        set the schema version to V without running any migrations`
+
+// runMigrateCommand implements the "migrate" subcommand, writing its progress to out.
+func runMigrateCommand(ctx context.Context, db *sql.DB, dialect data.Dialect, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := newMigrator(db, dialect)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				steps = -1
+			} else if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Fprintf(out, "rolled back %d_%s\n", m.Version, m.Name)
+		}
+		return err
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "version: %d\ndirty: %t\n", status.Version, status.Dirty)
+		if len(status.Pending) == 0 {
+			fmt.Fprintln(out, "pending: none")
+		}
+		for _, m := range status.Pending {
+			fmt.Fprintf(out, "pending: %d_%s\n", m.Version, m.Name)
+		}
+		return nil
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "forced version %d\n", version)
+		return nil
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// The migrateUp() method applies any pending migrations when the server starts, logging
+// each one which was applied.
+func (app *application) migrateUp(ctx context.Context, db *sql.DB, dialect data.Dialect) error {
+	migrator, err := newMigrator(db, dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		app.logger.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	return err
+}
+
+// newMigrator returns a Migrator for db using the embedded migrations for its dialect.
+func newMigrator(db *sql.DB, dialect data.Dialect) (*migrate.Migrator, error) {
+	files, err := migrations.For(string(dialect))
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, string(dialect), files)
+}
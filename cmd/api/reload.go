@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The stringList type is a flag.Value holding a list of strings, given as a comma or
+// space separated value (e.g. -cors-trusted-origins="https://a.com https://b.com").
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	return nil
+}
+
+// The runtimeConfig struct holds the subset of the configuration which can be changed
+// while the server is running. The active settings are stored in app.runtime and
+// replaced as a whole on reload, so a request always sees a consistent set of values.
+type runtimeConfig struct {
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	trustedOrigins []string
+	features       map[string]bool
+	maintenance    bool
+}
+
+// newRuntimeConfig extracts the reloadable settings from cfg.
+func newRuntimeConfig(cfg config) *runtimeConfig {
+	rt := &runtimeConfig{
+		trustedOrigins: cfg.cors.trustedOrigins,
+		features:       make(map[string]bool),
+		maintenance:    cfg.maintenance,
+	}
+	rt.limiter.rps = cfg.limiter.rps
+	rt.limiter.burst = cfg.limiter.burst
+	rt.limiter.enabled = cfg.limiter.enabled
+	for _, name := range cfg.features {
+		rt.features[name] = true
+	}
+	return rt
+}
+
+// reloadableSettings lists the settings which reloadConfig() applies to the running
+// server. Changes to any other setting are reported, but only take effect on restart.
+var reloadableSettings = map[string]bool{
+	"limiter-rps":          true,
+	"limiter-burst":        true,
+	"limiter-enabled":      true,
+	"log-level":            true,
+	"cors-trusted-origins": true,
+	"features":             true,
+	"maintenance":          true,
+}
+
+// The reloadResult struct describes the outcome of the most recent reload.
+type reloadResult struct {
+	Time            time.Time `json:"time"`
+	Success         bool      `json:"success"`
+	Error           string    `json:"error,omitempty"`
+	Changed         []string  `json:"changed"`
+	RequiresRestart []string  `json:"requires_restart"`
+}
+
+// The reloadConfig() method loads the configuration again from the same file,
+// environment and flags used at startup. If the new configuration is valid, the
+// reloadable settings are swapped in atomically; otherwise the running configuration is
+// left untouched. The result is logged, recorded in the metrics and kept for the admin
+// endpoint.
+func (app *application) reloadConfig() reloadResult {
+	// Only one reload runs at a time, so that two overlapping reloads can't both
+	// compare against the same old configuration.
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	result := reloadResult{Time: time.Now(), Changed: []string{}, RequiresRestart: []string{}}
+
+	cfg, _, _, err := loadConfig(app.configArgs)
+	if err != nil {
+		result.Error = err.Error()
+		app.logger.Error("configuration reload failed", "error", err.Error())
+	} else {
+		// Compare against the configuration we're actually running with: the startup
+		// configuration with the reloadable settings applied so far. Settings which need
+		// a restart keep being reported until the server is restarted.
+		active := app.activeConfig
+		for _, name := range diffConfig(active, cfg) {
+			if reloadableSettings[name] {
+				result.Changed = append(result.Changed, name)
+			} else {
+				result.RequiresRestart = append(result.RequiresRestart, name)
+			}
+		}
+
+		// Apply only the reloadable settings. app.config itself is never modified after
+		// startup, so handlers can read it without any locking.
+		active.limiter = cfg.limiter
+		active.logLevel = cfg.logLevel
+		active.cors = cfg.cors
+		active.features = cfg.features
+		active.maintenance = cfg.maintenance
+		app.activeConfig = active
+
+		app.logLevel.UnmarshalText([]byte(cfg.logLevel))
+		app.runtime.Store(newRuntimeConfig(cfg))
+
+		result.Success = true
+		app.logger.Info("configuration reloaded", "changed", result.Changed, "requires_restart", result.RequiresRestart)
+	}
+
+	app.metrics.observeReload(result.Success, result.Time)
+	app.lastReload.Store(&result)
+	return result
+}
+
+// diffConfig returns the names of the settings which differ between a and b.
+func diffConfig(a, b config) []string {
+	var changed []string
+	fsA, fsB := configFlagSet(&a), configFlagSet(&b)
+	fsA.VisitAll(func(f *flag.Flag) {
+		if f.Value.String() != fsB.Lookup(f.Name).Value.String() {
+			changed = append(changed, f.Name)
+		}
+	})
+	return changed
+}
+
+// configFlagSet returns a flag set bound to the fields of cfg without resetting them,
+// which lets diffConfig() compare two configurations setting by setting.
+func configFlagSet(cfg *config) *flag.FlagSet {
+	current := *cfg
+	fs := newConfigFlagSet(cfg)
+	// Registering the flags assigned the defaults, so put the real values back.
+	*cfg = current
+	return fs
+}
+
+// The watchReloadSignal() method reloads the configuration whenever the process
+// receives SIGHUP, until the server shuts down.
+func (app *application) watchReloadSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	app.background(func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				app.logger.Info("received SIGHUP, reloading configuration")
+				app.reloadConfig()
+			case <-app.done:
+				return
+			}
+		}
+	})
+}
+
+// The reloadConfigHandler() triggers a configuration reload from the admin listener and
+// returns its result. A failed reload is reported with 422 Unprocessable Entity, since
+// the configuration sources (rather than the request) are at fault.
+func (app *application) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	result := app.reloadConfig()
+
+	status := http.StatusOK
+	if !result.Success {
+		status = http.StatusUnprocessableEntity
+	}
+	err := app.writeJSON(w, status, envelope{"reload": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The reloadStatusHandler() returns the result of the most recent reload.
+func (app *application) reloadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	result := app.lastReload.Load()
+	if result == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"reload": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// featureEnabled reports whether the named feature toggle is currently switched on.
+func (app *application) featureEnabled(name string) bool {
+	return app.runtime.Load().features[name]
+}
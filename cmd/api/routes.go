@@ -2,7 +2,6 @@ package main
 
 import (
 	"net/http"
-	"os"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -11,34 +10,88 @@ func (app *application) routes() http.Handler {
 	// Initialize a new httprouter router instance.
 	router := httprouter.New()
 
+	// handle registers a handler on the router, recording the URL pattern it was
+	// registered with so that the access log and metrics can be grouped by route
+	// rather than by the raw URL.
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.recordRoute(pattern, handler))
+	}
+
 	// Register the relevant methods, URL patterns and handler functions for our
-	// endpoints using the HandlerFunc() method.
+	// endpoints using the handle() helper.
 
 	// handle serving the static files
-	router.ServeFiles("/static/*filepath", http.Dir(os.Getenv("sainpr_pfp_dir")))
+	fileServer := http.StripPrefix("/static", http.FileServer(http.Dir(app.config.pictureDir)))
+	handle(http.MethodGet, "/static/*filepath", fileServer.ServeHTTP)
 
 	// Set custom handlers for aftermentioned routes
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/livez", app.livenessHandler)
+	handle(http.MethodGet, "/readyz", app.readinessHandler)
 	// User Methods
-	router.HandlerFunc(http.MethodGet, "/v1/users/:email", app.requireAdmin(app.requireAuthenticatedUser(app.getUserHandler)))
-	router.HandlerFunc(http.MethodPut, "/v1/users/:email", app.requireAdmin(app.requireAuthenticatedUser(app.updateUserHandler)))
-	router.HandlerFunc(http.MethodDelete, "/v1/users/:email", app.requireAdmin(app.requireAuthenticatedUser(app.deleteUserHandler)))
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.createUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/:email/pfpicture", app.requireAdmin(app.requireAuthenticatedUser(app.insertImageHandler)))
-	// usercategory relations methods
-	router.HandlerFunc(http.MethodDelete, "/v1/user_categories", app.requireAdmin(app.requireAuthenticatedUser(app.deleteRelationsHandler)))
-	router.HandlerFunc(http.MethodPut, "/v1/user_categories", app.requireAdmin(app.requireAuthenticatedUser(app.setRelationsHandler)))
+	// The :user parameter is a user ID, "me" for the authenticated user, or an email
+	// address which is redirected to the ID (see requireUserOrAdmin).
+	handle(http.MethodGet, "/v1/users/:user", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.getUserHandler)))
+	handle(http.MethodPut, "/v1/users/:user", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.updateUserHandler)))
+	handle(http.MethodPatch, "/v1/users/:user", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.patchUserHandler)))
+	handle(http.MethodDelete, "/v1/users/:user", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.deleteUserHandler)))
+	handle(http.MethodPost, "/v1/users", app.createUserHandler)
+	handle(http.MethodPut, "/v1/users/:user/pfpicture", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.insertImageHandler)))
+	handle(http.MethodPut, "/v1/users/:user/password", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.updatePasswordHandler)))
+	handle(http.MethodDelete, "/v1/users/:user/lockout", app.requireAuthenticatedUser(app.requireAdmin(app.requireUserOrAdmin(app.unlockUserHandler))))
+	handle(http.MethodPost, "/v1/users/:user/totp", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.enrolTOTPHandler)))
+	handle(http.MethodPost, "/v1/users/:user/totp/confirm", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.confirmTOTPHandler)))
+	handle(http.MethodDelete, "/v1/users/:user/totp", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.deleteTOTPHandler)))
+	handle(http.MethodGet, "/v1/users/:user/categories", app.requireAuthenticatedUser(app.requireUserOrAdmin(app.getUserCategoriesHandler)))
+	handle(http.MethodDelete, "/v1/users/:user/categories/:id", app.requireAuthenticatedUser(app.requireAdmin(app.requireUserOrAdmin(app.deleteUserCategoryHandler))))
+	// usercategory relations methods. DELETE /v1/user_categories is kept for existing
+	// clients, the nested DELETE routes replace it
+	handle(http.MethodDelete, "/v1/user_categories", app.requireAdmin(app.requireAuthenticatedUser(app.deleteRelationsHandler)))
+	handle(http.MethodPut, "/v1/user_categories", app.requireAdmin(app.requireAuthenticatedUser(app.setRelationsHandler)))
 	// Category methods
-	router.HandlerFunc(http.MethodPost, "/v1/categories", app.requireAdmin(app.requireAuthenticatedUser(app.createCategoryHandler)))
-	router.HandlerFunc(http.MethodGet, "/v1/categories", app.requireAuthenticatedUser(app.getCategoriesHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/categories/:id", app.requireAdmin(app.requireAuthenticatedUser(app.updateCategoryHandler)))
-	router.HandlerFunc(http.MethodDelete, "/v1/categories/:id", app.requireAdmin(app.requireAuthenticatedUser(app.deleteCategoryHandler)))
+	handle(http.MethodPost, "/v1/categories", app.requireAdmin(app.requireAuthenticatedUser(app.createCategoryHandler)))
+	handle(http.MethodGet, "/v1/categories", app.requireAuthenticatedUser(app.getCategoriesHandler))
+	handle(http.MethodPut, "/v1/categories/:id", app.requireAdmin(app.requireAuthenticatedUser(app.updateCategoryHandler)))
+	handle(http.MethodPatch, "/v1/categories/:id", app.requireAdmin(app.requireAuthenticatedUser(app.patchCategoryHandler)))
+	handle(http.MethodDelete, "/v1/categories/:id", app.requireAdmin(app.requireAuthenticatedUser(app.deleteCategoryHandler)))
+	handle(http.MethodGet, "/v1/categories/:id/users", app.requireAdmin(app.requireAuthenticatedUser(app.getCategoryUsersHandler)))
+	handle(http.MethodDelete, "/v1/categories/:id/users/:user", app.requireAuthenticatedUser(app.requireAdmin(app.requireUserOrAdmin(app.deleteUserCategoryHandler))))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/mfa", app.createMFATokenHandler)
 
 	// Return the httprouter instance.
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	rateLimit := app.traceMiddleware("rateLimit", app.rateLimit)
+	authenticate := app.traceMiddleware("authenticate", app.authenticate)
+	limited := rateLimit(authenticate(router))
+
+	// The probes skip the rate limiter, so that a busy client IP, or a kubelet sharing
+	// one behind NAT, can't have a probe turned away and the server restarted. They
+	// don't need authenticating either.
+	probes := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/livez", "/readyz":
+			router.ServeHTTP(w, r)
+		default:
+			limited.ServeHTTP(w, r)
+		}
+	})
+
+	return app.requestID(app.logRequest(app.startTrace(app.recoverPanic(app.enableCORS(app.maintenanceMode(probes))))))
+}
+
+// adminRoutes returns the handler for the admin listener. These endpoints expose
+// operational details about the server, so they are served on a separate port which
+// shouldn't be reachable from the public internet.
+func (app *application) adminRoutes() http.Handler {
+	router := httprouter.New()
+
+	router.Handler(http.MethodGet, "/metrics", app.metrics.handler())
+	router.HandlerFunc(http.MethodGet, "/config/reload", app.reloadStatusHandler)
+	router.HandlerFunc(http.MethodPost, "/config/reload", app.reloadConfigHandler)
+
+	return app.recoverPanic(router)
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticFiles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	code, _, body := ts.do(t, http.MethodGet, "/static/defaultpfp.jpeg", nil, "")
+	if code != http.StatusOK || string(body) != "default picture" {
+		t.Errorf("got status %d and body %q; want the default picture", code, body)
+	}
+
+	code, _, _ = ts.do(t, http.MethodGet, "/static/missing.png", nil, "")
+	if code != http.StatusNotFound {
+		t.Errorf("got status %d for a missing file; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		wantCode int
+	}{
+		{"Unknown path", http.MethodGet, "/v1/nothing", http.StatusNotFound},
+		{"Unsupported method", http.MethodPatch, "/v1/categories", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.do(t, tt.method, tt.urlPath, nil, "")
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if ct := header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q; want application/json", ct)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{"Valid token", "Bearer " + aliceToken, http.StatusOK},
+		{"Malformed header", "Token " + aliceToken, http.StatusUnauthorized},
+		{"Invalid signature", "Bearer " + aliceToken + "x", http.StatusUnauthorized},
+		{"Expired token", "Bearer " + newTestToken(t, alice.ID, time.Now().Add(-time.Hour)), http.StatusUnauthorized},
+		{"Unknown user", "Bearer " + newTestToken(t, 99, time.Now().Add(time.Hour)), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/categories", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", tt.header)
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("got status %d; want %d", rs.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/livez", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "abc-123")
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	if got := rs.Header.Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("got X-Request-ID %q; want the one sent by the client", got)
+	}
+
+	_, header, _ := ts.do(t, http.MethodGet, "/livez", nil, "")
+	if len(header.Get("X-Request-ID")) != 32 {
+		t.Errorf("got X-Request-ID %q; want a generated 32 character ID", header.Get("X-Request-ID"))
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	app := newTestApplication(t)
+	cfg := app.config
+	cfg.maintenance = true
+	app.runtime.Store(newRuntimeConfig(cfg))
+	ts := newTestServer(t, app.routes())
+
+	code, header, _ := ts.do(t, http.MethodGet, "/v1/categories", nil, "")
+	if code != http.StatusServiceUnavailable || header.Get("Retry-After") == "" {
+		t.Errorf("got status %d and Retry-After %q; want %d with Retry-After", code, header.Get("Retry-After"), http.StatusServiceUnavailable)
+	}
+
+	code, _, _ = ts.do(t, http.MethodGet, "/v1/healthcheck", nil, "")
+	if code != http.StatusOK {
+		t.Errorf("got healthcheck status %d during maintenance; want %d", code, http.StatusOK)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	app.configArgs = []string{"-db-dsn", "postgres://localhost/test", "-jwt-secret", testJWTSecret, "-log-level", "debug"}
+	ts := newTestServer(t, app.adminRoutes())
+
+	code, _, _ := ts.do(t, http.MethodGet, "/config/reload", nil, "")
+	if code != http.StatusNotFound {
+		t.Errorf("got status %d before any reload; want %d", code, http.StatusNotFound)
+	}
+
+	code, _, body := ts.do(t, http.MethodPost, "/config/reload", nil, "")
+	if code != http.StatusOK {
+		t.Fatalf("got reload status %d; want %d (body %s)", code, http.StatusOK, body)
+	}
+	if !strings.Contains(string(body), `"log-level"`) {
+		t.Errorf("reload result %s doesn't report the changed log level", body)
+	}
+
+	code, _, _ = ts.do(t, http.MethodGet, "/config/reload", nil, "")
+	if code != http.StatusOK {
+		t.Errorf("got status %d after a reload; want %d", code, http.StatusOK)
+	}
+
+	code, _, body = ts.do(t, http.MethodGet, "/metrics", nil, "")
+	if code != http.StatusOK || !strings.Contains(string(body), "config_reloads_total") {
+		t.Errorf("got metrics status %d; want %d with the reload counter", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The serve() method starts the API server (and the admin listener, if enabled) and
+// blocks until the process receives SIGINT or SIGTERM. On shutdown, readiness is
+// failed first so load balancers stop sending new requests, then in-flight requests are
+// given up to the configured drain timeout to complete, and finally we wait for any
+// background goroutines to exit.
+func (app *application) serve() error {
+	// Every request context derives from baseCtx, which is cancelled if requests are
+	// still running when the drain timeout expires. The models pass the request
+	// context on to the database, so this abandons their queries too.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		// Route errors from the http.Server itself (TLS handshake failures, malformed
+		// requests etc.) through our structured logger as well.
+		ErrorLog: slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	var adminSrv *http.Server
+	if app.config.adminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:         app.config.adminAddr,
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+	}
+
+	// The shutdownError channel receives any errors returned by the graceful
+	// Shutdown() calls.
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// Fail the readiness probe straight away, and give whatever is routing traffic
+		// to us a moment to notice before we stop accepting connections.
+		app.shuttingDown.Store(true)
+		time.Sleep(app.config.shutdown.delay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
+		defer cancel()
+
+		// Shutdown() stops the listeners and waits for in-flight requests to complete,
+		// returning an error if the drain timeout expires first.
+		err := srv.Shutdown(ctx)
+		if adminSrv != nil {
+			err = errors.Join(err, adminSrv.Shutdown(ctx))
+		}
+		if err != nil {
+			// The drain timeout expired, so cancel the requests still running.
+			cancelRequests()
+		}
+
+		// Tell the background goroutines to stop, and wait for them to finish, even if
+		// the drain failed, so that none are left running as the process exits.
+		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		close(app.done)
+		app.wg.Wait()
+		shutdownError <- err
+	}()
+
+	// Reload the configuration on SIGHUP.
+	app.watchReloadSignal()
+
+	// Start the admin listener in the background. It only serves operational
+	// endpoints, so a failure here is logged but doesn't stop the API itself.
+	if adminSrv != nil {
+		go func() {
+			app.logger.Info("starting admin server", "addr", adminSrv.Addr)
+			err := adminSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("admin server stopped", "error", err.Error())
+			}
+		}()
+	}
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+
+	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
+	// return a http.ErrServerClosed error. So if we see this error, it is actually a
+	// good thing and an indication that the graceful shutdown has started.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	// Otherwise, we wait to receive the return value from Shutdown() on the
+	// shutdownError channel.
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
+
+// The background() helper runs fn in a goroutine which serve() waits for during
+// shutdown. Long-running functions should return once app.done is closed.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		// Recover any panic, as it would otherwise bring down the whole server.
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+		fn()
+	}()
+}
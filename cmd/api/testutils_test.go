@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/jwt"
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/password"
+)
+
+const testJWTSecret = "test-secret"
+
+// newTestApplication returns an application which uses the in-memory models, a
+// temporary picture directory and a discarding logger. The rate limiter is disabled so
+// that tests can send as many requests as they like.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "development"
+	cfg.logLevel = "info"
+	cfg.jwt.secret = testJWTSecret
+	cfg.pictureDir = t.TempDir()
+	cfg.shutdown.timeout = time.Second
+	cfg.features = stringList{"registration"}
+	cfg.mfa.issuer = "sainpr"
+	cfg.mfa.challengeTTL = 5 * time.Minute
+
+	err := os.WriteFile(filepath.Join(cfg.pictureDir, "defaultpfp.jpeg"), []byte("default picture"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config:         cfg,
+		logger:         slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		metrics:        newMetrics(nil, ""),
+		models:         data.NewMemoryModels(password.DefaultHasher()),
+		passwordPolicy: password.DefaultPolicy(),
+		passwordHasher: password.DefaultHasher(),
+		done:           make(chan struct{}),
+		logLevel:       new(slog.LevelVar),
+		activeConfig:   cfg,
+	}
+	app.runtime.Store(newRuntimeConfig(cfg))
+	t.Cleanup(func() { close(app.done) })
+	return app
+}
+
+// testServer wraps httptest.Server with helpers for sending requests to it.
+type testServer struct {
+	*httptest.Server
+}
+
+// newTestServer starts a server for h which is closed when the test finishes.
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// do sends a request with an optional JSON body and bearer token, and returns the
+// status code, headers and body of the response.
+func (ts *testServer) do(t *testing.T, method, urlPath string, body any, token string) (int, http.Header, []byte) {
+	t.Helper()
+	return ts.doWithHeader(t, method, urlPath, body, token, nil)
+}
+
+// doWithHeader is like do, but also sends the given request headers.
+func (ts *testServer) doWithHeader(t *testing.T, method, urlPath string, body any, token string, header http.Header) (int, http.Header, []byte) {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+	case string:
+		reader = bytes.NewBufferString(b)
+	default:
+		js, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	respBody, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, respBody
+}
+
+// decodeJSON unmarshals a response body into dst, failing the test if it isn't valid.
+func decodeJSON(t *testing.T, body []byte, dst any) {
+	t.Helper()
+	if err := json.Unmarshal(body, dst); err != nil {
+		t.Fatalf("decoding response %q: %v", body, err)
+	}
+}
+
+// createTestUser adds a user directly through the models, optionally granting them admin
+// rights, and returns it along with a valid authentication token.
+func createTestUser(t *testing.T, app *application, name, email string, admin bool) (data.User, string) {
+	t.Helper()
+
+	user := data.User{Name: name, Email: email, Password: "pa55word1234"}
+	id, err := app.models.Users.UserCreate(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.ID = id
+	if admin {
+		if err := app.models.Users.AdminGrant(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user, newTestToken(t, id, time.Now().Add(time.Hour))
+}
+
+// newTestToken signs a token for the user, in the same way as the authentication
+// endpoint, which expires at the given time.
+func newTestToken(t *testing.T, userID int, expires time.Time) string {
+	t.Helper()
+
+	var claims jwt.Claims
+	claims.Subject = strconv.Itoa(userID)
+	claims.Issued = jwt.NewNumericTime(time.Now().Add(-time.Hour * 2))
+	claims.NotBefore = jwt.NewNumericTime(time.Now().Add(-time.Hour * 2))
+	claims.Expires = jwt.NewNumericTime(expires)
+	claims.Issuer = "interview_assignment.mohamednaas.net"
+	claims.Audiences = []string{"interview_assignment.mohamednaas.net"}
+
+	token, err := claims.HMACSign(jwt.HS256, []byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
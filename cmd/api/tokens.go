@@ -2,13 +2,13 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/pascaldekloe/jwt"
 	"interview_assignment.mohamednaas.net/internal/data"
-	"interview_assignment.mohamednaas.net/internal/validator"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -21,52 +21,118 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	user := data.User{
-		Email:    input.Email,
-		Password: input.Password,
+	// Turn away clients which have to wait after failed logins before even checking the
+	// password, so that guesses made during the wait can't succeed. Otherwise the
+	// attempt is counted as a failure until it's known not to be one
+	attempt, wait, err := app.reserveLoginAttempt(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
-	v := validator.New()
-	data.ValidateUserRegisteration(v, &user)
-	user, err = app.models.Users.UserGet(input.Email, *r)
+	if wait > 0 {
+		app.audit(r, auditLoginBlocked, "email", input.Email, "retry_after", wait.Round(time.Second).String())
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+	defer app.releaseLoginAttempt(r, attempt)
+	// The password policy only applies to new passwords, so the credentials aren't
+	// validated beyond checking them against the stored user
+	user, err := app.models.Users.UserGet(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			// Take as long as checking a real password would, so that the response
+			// time doesn't reveal which email addresses have accounts
+			app.passwordHasher.CompareDummy(input.Password)
+			app.loginFailed(r, attempt, "unknown_email")
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	match, err := app.models.Users.CheckPasswordMatches(user, input.Password)
+	match, err := app.models.Users.CheckPasswordMatches(r.Context(), user, input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	if !match {
+		app.loginFailed(r, attempt, "wrong_password")
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
+	// Now that the password is known to be right, replace its hash if it was made with
+	// an older algorithm or weaker parameters than the configured ones. Failing to do so
+	// doesn't stop the user logging in; it's tried again next time.
+	rehashed, err := app.models.Users.UserRehashPassword(r.Context(), user, input.Password)
+	if err != nil {
+		app.logError(r, fmt.Errorf("rehashing password: %w", err))
+	} else if rehashed {
+		app.logger.Info("password rehashed", "user_id", user.ID)
+	}
+	// Users with two-factor authentication get a challenge token to exchange for the
+	// real one at /v1/tokens/mfa along with a code, see mfa.go. Their failed logins are
+	// kept until then, so that a known password doesn't reset the count of wrong codes;
+	// only this attempt is taken back, by the deferred release.
+	mfa, err := app.mfaEnabled(r, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if mfa {
+		token, err := app.signToken(user.ID, mfaAudience, app.config.mfa.challengeTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": token}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.loginSucceeded(r, attempt)
+	app.authenticationTokenResponse(w, r, user.ID)
+}
+
+// tokenIssuer is the issuer of every JWT we sign, and the audience of the
+// authentication tokens.
+const tokenIssuer = "interview_assignment.mohamednaas.net"
+
+// The signToken() method returns a JWT for the user which is valid for the audience for
+// the given time.
+func (app *application) signToken(userID int, audience string, ttl time.Duration) (string, error) {
 	// Create a JWT claims struct containing the user ID as the subject, with an issued
-	// time of now and validity window of the next 24 hours. We also set the issuer and
-	// audience to a unique identifier for our application.
+	// time of now and validity window of ttl. We also set the issuer and audience to
+	// unique identifiers for our application.
 	var claims jwt.Claims
-	claims.Subject = strconv.FormatInt(int64(user.ID), 10)
+	claims.Subject = strconv.FormatInt(int64(userID), 10)
 	claims.Issued = jwt.NewNumericTime(time.Now())
 	claims.NotBefore = jwt.NewNumericTime(time.Now())
-	claims.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour))
-	claims.Issuer = "interview_assignment.mohamednaas.net"
+	claims.Expires = jwt.NewNumericTime(time.Now().Add(ttl))
+	claims.Issuer = tokenIssuer
 
-	claims.Audiences = []string{"interview_assignment.mohamednaas.net"}
+	claims.Audiences = []string{audience}
 	// Sign the JWT claims using the HMAC-SHA256 algorithm and the secret key from the
 	// application config. This returns a []byte slice containing the JWT as a base64-
 	// encoded string.
 	jwtBytes, err := claims.HMACSign(jwt.HS256, []byte(app.config.jwt.secret))
+	if err != nil {
+		return "", err
+	}
+	return string(jwtBytes), nil
+}
+
+// The authenticationTokenResponse() method sends a new authentication token for the
+// user, valid for the next 24 hours, once they have logged in.
+func (app *application) authenticationTokenResponse(w http.ResponseWriter, r *http.Request, userID int) {
+	token, err := app.signToken(userID, tokenIssuer, 24*time.Hour)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Convert the []byte slice to a string and return it in a JSON response.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": string(jwtBytes)}, nil)
+	// Return the token in a JSON response.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
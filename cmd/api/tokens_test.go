@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+func TestCreateAuthenticationToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+	}{
+		{"Valid credentials", map[string]string{"email": "alice@example.com", "password": "pa55word1234"}, http.StatusCreated},
+		{"Wrong password", map[string]string{"email": "alice@example.com", "password": "wrongpassword"}, http.StatusUnauthorized},
+		{"Unknown email", map[string]string{"email": "bob@example.com", "password": "pa55word1234"}, http.StatusUnauthorized},
+		{"Malformed JSON", `{"email": "alice@example.com"`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", tt.body, "")
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code != http.StatusCreated {
+				return
+			}
+
+			var resp struct {
+				Token string `json:"authentication_token"`
+			}
+			decodeJSON(t, body, &resp)
+
+			// The token must be accepted by the authenticate middleware.
+			code, _, body = ts.do(t, http.MethodGet, "/v1/users/alice@example.com", nil, resp.Token)
+			if code != http.StatusOK {
+				t.Errorf("got status %d using the new token; want %d (body %s)", code, http.StatusOK, body)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.login.lockoutEnabled = true
+	app.config.login.maxAccountFailures = 3
+	app.config.login.maxClientFailures = 100
+	app.config.login.lockoutDuration = time.Hour
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	createTestUser(t, app, "Alice", "alice@example.com", false)
+	login := func(email, password string) (int, http.Header) {
+		code, header, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{"email": email, "password": password}, "")
+		return code, header
+	}
+
+	// A failure makes the account wait before the next attempt, even with the right
+	// password: a quarter of the lockout after the first of three failures.
+	if code, _ := login("alice@example.com", "wrongpassword"); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d for a wrong password; want %d", code, http.StatusUnauthorized)
+	}
+	code, header := login("ALICE@example.com", "pa55word1234")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d right after a failure; want %d", code, http.StatusTooManyRequests)
+	}
+	if retry, _ := strconv.Atoi(header.Get("Retry-After")); retry < 890 || retry > 900 {
+		t.Errorf("got Retry-After %q; want about 900", header.Get("Retry-After"))
+	}
+	// Other accounts, including ones which don't exist, are counted separately.
+	if code, _ := login("bob@example.com", "pa55word1234"); code != http.StatusUnauthorized {
+		t.Errorf("got status %d for an unknown email; want %d", code, http.StatusUnauthorized)
+	}
+
+	// Reaching the maximum locks the account out for the whole lockout duration.
+	ctx := context.Background()
+	key := data.AccountLoginKey("alice@example.com")
+	for i := 0; i < 2; i++ {
+		if _, err := app.models.LoginFailures.LoginFailureRecord(ctx, key, time.Now(), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, header := login("alice@example.com", "pa55word1234"); header.Get("Retry-After") != "3600" {
+		t.Errorf("got Retry-After %q for a locked account; want 3600", header.Get("Retry-After"))
+	}
+
+	// An admin can lift the lockout, after which the right password works again.
+	if code, _, body := ts.do(t, http.MethodDelete, "/v1/users/2/lockout", nil, ""); code != http.StatusUnauthorized {
+		t.Errorf("got status %d unlocking anonymously; want %d (body %s)", code, http.StatusUnauthorized, body)
+	}
+	if code, _, body := ts.do(t, http.MethodDelete, "/v1/users/2/lockout", nil, adminToken); code != http.StatusOK {
+		t.Fatalf("got status %d unlocking; want %d (body %s)", code, http.StatusOK, body)
+	}
+	if code, _ := login("alice@example.com", "pa55word1234"); code != http.StatusCreated {
+		t.Errorf("got status %d after unlocking; want %d", code, http.StatusCreated)
+	}
+
+	// A client which reaches its own maximum is locked out of every account.
+	for i := 0; i < 100; i++ {
+		if _, err := app.models.LoginFailures.LoginFailureRecord(ctx, data.ClientLoginKey("127.0.0.1"), time.Now(), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code, _ := login("alice@example.com", "pa55word1234"); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d from a locked out client; want %d", code, http.StatusTooManyRequests)
+	}
+
+	// The failures, blocked attempts and unlock are all in the audit trail.
+	events := map[string]int{}
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry struct {
+			Msg    string `json:"msg"`
+			Event  string `json:"event"`
+			Reason string `json:"reason"`
+		}
+		decodeJSON(t, line, &entry)
+		if entry.Msg == "audit" {
+			events[entry.Event+" "+entry.Reason]++
+		}
+	}
+	want := map[string]int{
+		"login_failed wrong_password": 1,
+		"login_failed unknown_email":  1,
+		"login_blocked ":              3,
+		"login_unlocked ":             1,
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got audit events %v; want %v", events, want)
+	}
+}
+
+func TestLoginLockoutConcurrent(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.login.lockoutEnabled = true
+	app.config.login.maxAccountFailures = 3
+	app.config.login.maxClientFailures = 100
+	app.config.login.lockoutDuration = time.Hour
+	ts := newTestServer(t, app.routes())
+
+	createTestUser(t, app, "Alice", "alice@example.com", false)
+	createTestUser(t, app, "Bob", "bob@example.com", false)
+
+	// Guesses sent all at once must wait for each other like guesses sent one after
+	// another: only the first gets to check its password, and the rest are turned away
+	// as if it had already failed.
+	const guesses = 20
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		codes = map[int]int{}
+	)
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, _, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{"email": "alice@example.com", "password": "wrongpassword"}, "")
+			mu.Lock()
+			codes[code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if want := map[int]int{http.StatusUnauthorized: 1, http.StatusTooManyRequests: guesses - 1}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("got status codes %v; want %v", codes, want)
+	}
+
+	// Only the guess which was checked is counted, and a successful login from the same
+	// client takes back its own attempt.
+	if code, _, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", map[string]string{"email": "bob@example.com", "password": "pa55word1234"}, ""); code != http.StatusCreated {
+		t.Fatalf("got status %d logging in to another account; want %d (body %s)", code, http.StatusCreated, body)
+	}
+	ctx := context.Background()
+	for _, key := range []string{data.AccountLoginKey("alice@example.com"), data.ClientLoginKey("127.0.0.1")} {
+		failure, err := app.models.LoginFailures.LoginFailureGet(ctx, key)
+		if err != nil || failure.Failures != 1 {
+			t.Errorf("got %+v, %v for %s; want 1 failure", failure, err, key)
+		}
+	}
+}
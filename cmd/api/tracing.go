@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The name of the tracer used for the spans created by the API server.
+const tracerName = "interview_assignment.mohamednaas.net/cmd/api"
+
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing installs the global OpenTelemetry tracer provider and W3C trace-context
+// propagator, using the exporter selected in the config. It returns a function which
+// flushes any buffered spans and releases the exporter. When tracing is disabled the
+// global no-op provider is left in place and the returned function does nothing.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	// Always accept and propagate the W3C traceparent/tracestate and baggage headers,
+	// even if we don't export anything ourselves, so that we don't break the trace of a
+	// caller which does.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+	switch cfg.tracing.exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// Without an explicit endpoint the exporter falls back to the standard
+		// OTEL_EXPORTER_OTLP_* environment variables.
+		var opts []otlptracehttp.Option
+		if cfg.tracing.endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.tracing.endpoint))
+		}
+		if cfg.tracing.insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case "stdout":
+		// Write spans as JSON to a file if one was given (handy for collecting traces
+		// on a machine with no collector), or to stdout otherwise.
+		w := io.Writer(os.Stdout)
+		if cfg.tracing.file != "" {
+			f, ferr := os.OpenFile(cfg.tracing.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if ferr != nil {
+				return nil, ferr
+			}
+			w, closer = f, f
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.tracing.exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("interview-assignment-api"),
+		semconv.ServiceVersion(version),
+		semconv.DeploymentEnvironment(cfg.env),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.tracing.sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// The startTrace() middleware extracts the trace context sent by the client (if any)
+// and starts the server span for the request. Once the request has been routed, the
+// span is renamed after the matched route pattern so that spans for the same endpoint
+// are grouped together.
+func (app *application) startTrace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(r.RemoteAddr),
+				attribute.String("request.id", app.contextGetRequestID(r)),
+			),
+		)
+		defer span.End()
+
+		entry := app.contextGetAccessLog(r)
+		if entry != nil {
+			entry.traceID = span.SpanContext().TraceID().String()
+		}
+
+		lw := newLoggingResponseWriter(w)
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		if entry != nil && entry.route != "" {
+			span.SetName(r.Method + " " + entry.route)
+			span.SetAttributes(semconv.HTTPRoute(entry.route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(lw.statusCode))
+		if lw.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(lw.statusCode))
+		}
+	})
+}
+
+// traceMiddleware wraps a middleware so that the time spent in it (and in everything
+// further down the chain) shows up as its own span.
+func (app *application) traceMiddleware(name string, middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracer.Start(r.Context(), "middleware "+name)
+			defer span.End()
+			wrapped.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -2,8 +2,118 @@ package main
 
 import (
 	"net/http"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/validator"
 )
 
+// Handler for listing the categories assigned to a user a page at a time, with when
+// and by whom each one was assigned, e.g. GET /v1/users/me/categories?page=2
+func (app *application) getUserCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Make sure the user exists, so a missing user is a 404 rather than an empty list
+	var (
+		grants   []*data.CategoryGrant
+		metadata data.Metadata
+	)
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		if _, err := tx.Users.UserGetID(r.Context(), int64(id)); err != nil {
+			return err
+		}
+		grants, metadata, err = tx.UserCategories.UserCategoryGrantsGet(r.Context(), id, filters)
+		return err
+	})
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"categories": grants, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Handler for listing the users a category is assigned to a page at a time, with when
+// and by whom it was assigned
+func (app *application) getCategoryUsersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readFilters(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Make sure the category exists, so a missing one is a 404 rather than an empty list
+	var (
+		grants   []*data.UserGrant
+		metadata data.Metadata
+	)
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		if _, err := tx.Categories.CategoryGet(r.Context(), id); err != nil {
+			return err
+		}
+		grants, metadata, err = tx.UserCategories.CategoryUsersGet(r.Context(), id, filters)
+		return err
+	})
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	for _, grant := range grants {
+		grant.Picture = app.pictureURL(r, grant.Picture)
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": grants, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Handler for removing a category from a user, which is reachable from either side:
+// DELETE /v1/users/:user/categories/:id and DELETE /v1/categories/:id/users/:user
+func (app *application) deleteUserCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readUserParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	categoryID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.UserCategories.DeleteUserCategories(r.Context(), userID, categoryID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "relations removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // Handle setting and updating category relations with a user
 func (app *application) setRelationsHandler(w http.ResponseWriter, r *http.Request) {
 	// strcuture input
@@ -19,9 +129,9 @@ func (app *application) setRelationsHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.UserCategories.InsertUserCategories(input.UserID, input.CategoryID)
+	err = app.models.UserCategories.InsertUserCategories(r.Context(), input.UserID, input.CategoryID, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -46,7 +156,11 @@ func (app *application) deleteRelationsHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	app.models.UserCategories.DeleteUserCategories(input.UserID, input.CategoryID)
+	err = app.models.UserCategories.DeleteUserCategories(r.Context(), input.UserID, input.CategoryID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "relations removed"}, nil)
 	if err != nil {
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+func TestUserCategoryRelations(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+	categoryID, err := app.models.Categories.CategoryCreate(context.Background(), data.Category{Name: "Books"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relation := map[string]int{"user_id": alice.ID, "category_id": categoryID}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     map[string]int
+		token    string
+		wantCode int
+		wantLen  int
+	}{
+		{"Non-admin assigns", http.MethodPut, relation, aliceToken, http.StatusUnauthorized, 0},
+		{"Missing user", http.MethodPut, map[string]int{"user_id": 99, "category_id": categoryID}, adminToken, http.StatusUnprocessableEntity, 0},
+		{"Missing category", http.MethodPut, map[string]int{"user_id": alice.ID, "category_id": 99}, adminToken, http.StatusUnprocessableEntity, 0},
+		{"Admin assigns", http.MethodPut, relation, adminToken, http.StatusCreated, 1},
+		{"Already assigned", http.MethodPut, relation, adminToken, http.StatusConflict, 1},
+		{"Non-admin removes", http.MethodDelete, relation, aliceToken, http.StatusUnauthorized, 1},
+		{"Admin removes", http.MethodDelete, relation, adminToken, http.StatusOK, 0},
+		{"Not assigned", http.MethodDelete, relation, adminToken, http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, tt.method, "/v1/user_categories", tt.body, tt.token)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+
+			categories, err := app.models.UserCategories.UserCategoriesGet(context.Background(), alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(categories) != tt.wantLen {
+				t.Errorf("user has %d categories; want %d", len(categories), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestNestedUserCategoryRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	ctx := context.Background()
+
+	admin, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+	var categoryIDs []int
+	for _, name := range []string{"Books", "Films", "Music"} {
+		id, err := app.models.Categories.CategoryCreate(ctx, data.Category{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.models.UserCategories.InsertUserCategories(ctx, alice.ID, id, admin.ID); err != nil {
+			t.Fatal(err)
+		}
+		categoryIDs = append(categoryIDs, id)
+	}
+	aliceURL := fmt.Sprintf("/v1/users/%d", alice.ID)
+	categoryURL := fmt.Sprintf("/v1/categories/%d", categoryIDs[0])
+
+	t.Run("Paginated categories", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, "/v1/users/me/categories?page=2&page_size=2", nil, aliceToken)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+		}
+		var resp struct {
+			Categories []data.CategoryGrant `json:"categories"`
+			Metadata   data.Metadata        `json:"metadata"`
+		}
+		decodeJSON(t, body, &resp)
+		if len(resp.Categories) != 1 || resp.Categories[0].ID != categoryIDs[2] {
+			t.Errorf("got categories %+v on page 2; want only %d", resp.Categories, categoryIDs[2])
+		}
+		if g := resp.Categories[0].GrantedBy; g == nil || *g != admin.ID {
+			t.Errorf("got granted_by %v; want %d", g, admin.ID)
+		}
+		if resp.Metadata.LastPage != 2 || resp.Metadata.TotalRecords != 3 {
+			t.Errorf("got metadata %+v; want 3 records over 2 pages", resp.Metadata)
+		}
+	})
+
+	t.Run("Invalid filters", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, "/v1/users/me/categories?page=0&page_size=x", nil, aliceToken)
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d; want %d (body %s)", code, http.StatusUnprocessableEntity, body)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		wantCode int
+	}{
+		{"Non-admin lists category users", http.MethodGet, categoryURL + "/users", aliceToken, http.StatusUnauthorized},
+		{"Admin lists category users", http.MethodGet, categoryURL + "/users", adminToken, http.StatusOK},
+		{"Missing category users", http.MethodGet, "/v1/categories/99/users", adminToken, http.StatusNotFound},
+		{"Non-admin removes", http.MethodDelete, fmt.Sprintf("%s/categories/%d", aliceURL, categoryIDs[0]), aliceToken, http.StatusUnauthorized},
+		{"Admin removes from user", http.MethodDelete, fmt.Sprintf("%s/categories/%d", aliceURL, categoryIDs[0]), adminToken, http.StatusOK},
+		{"Already removed", http.MethodDelete, fmt.Sprintf("%s/categories/%d", aliceURL, categoryIDs[0]), adminToken, http.StatusNotFound},
+		{"Admin removes from category", http.MethodDelete, fmt.Sprintf("/v1/categories/%d/users/%d", categoryIDs[1], alice.ID), adminToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, tt.method, tt.urlPath, nil, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	categories, err := app.models.UserCategories.UserCategoriesGet(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(categories) != 1 || categories[0].ID != categoryIDs[2] {
+		t.Errorf("got user categories %v; want only %d", categories, categoryIDs[2])
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"interview_assignment.mohamednaas.net/internal/data"
 	"interview_assignment.mohamednaas.net/internal/validator"
@@ -15,6 +16,25 @@ import (
 func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Create a new user and add them to the database
 
+	// Self-registration can be switched off with the "registration" feature toggle, in
+	// which case only admins can create users.
+	if !app.featureEnabled("registration") {
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.featureDisabledResponse(w, r)
+			return
+		}
+		isAdmin, err := app.hasAdminRights(r, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !isAdmin {
+			app.featureDisabledResponse(w, r)
+			return
+		}
+	}
+
 	// Create the input structure
 	var input struct {
 		Name     string `json:"name"`
@@ -27,6 +47,7 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	// Read the input into appropriate structure
@@ -39,29 +60,28 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 	// Validate the clients input
 	v := validator.New()
 
-	if data.ValidateUserRegisteration(v, user); !v.Valid() {
+	if data.ValidateUserRegisteration(v, user, app.passwordPolicy); !v.Valid() {
 		// Validation failed, send appropriate error messages
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Validation succesful, attempt to create user.
 	// Inserting user into database
-	id, err := app.models.Users.UserCreate(*user)
-
+	id, err := app.models.Users.UserCreate(r.Context(), *user)
 	if err != nil {
-		if err == data.ErrDuplicateEmail {
-			app.badRequestResponse(w, r, err)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
+	// Include a Location header pointing at the new user's ID-based route
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d", id))
+
 	env := envelope{
 		"meassage": "User Created Sucessfully",
 		"id":       id,
 	}
-	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -75,32 +95,35 @@ func (app *application) insertImageHandler(w http.ResponseWriter, r *http.Reques
 	byte, err := io.ReadAll(r.Body)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 	mimeType := http.DetectContentType(byte)
 
-	// Get the email for the user whose pfp is to be added
-	email, err := app.readEmailParam(r)
+	// Get the ID of the user whose pfp is to be added
+	id, err := app.readUserParam(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	// use email to fetch other relevant info
+	// use the ID to fetch other relevant info
 	// Fetch user info from database
-	user, err := app.models.Users.UserGet(email, *r)
+	user, err := app.models.Users.UserGetID(r.Context(), int64(id))
 	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
-		}
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	// Refuse to change the picture if the client's copy of the user is out of date
+	if !app.ifMatch(r, user.Version) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
 	// Make sure that the sent reuqest conatins an image
 	v := validator.New()
 	if v.Check(validator.PermitedFileType(mimeType, "image/jpeg", "image/jpg", "image/png"),
-		"image", "Improper File Type"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		"image", "validation.image.type"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -129,25 +152,30 @@ func (app *application) insertImageHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Save image filepath into database
-	err = app.models.Users.UserUpdatePicture(fName, user.Email)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// fetch user one last time ensure updated info
-	// Fetch user info from database
-	user, err = app.models.Users.UserGet(user.Email, *r)
+	// Save image filepath into database and fetch the user one last time to ensure
+	// updated info, in one transaction so the reply matches what was stored
+	previous := user.Picture
+	var updated data.User
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		if err := tx.Users.UserUpdatePicture(r.Context(), fName, user.Email); err != nil {
+			return err
+		}
+		var err error
+		updated, err = tx.Users.UserGetID(r.Context(), int64(id))
+		return err
+	})
 	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
+		// Don't leave behind a file which no user refers to
+		if fName != previous {
+			os.Remove(fPath)
 		}
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
+	user = updated
 
+	user.Picture = app.pictureURL(r, user.Picture)
+	w.Header().Set("ETag", etag(user.Version))
 	env := envelope{
 		"Message": "Image added succesfully",
 		"User":    user,
@@ -159,29 +187,50 @@ func (app *application) insertImageHandler(w http.ResponseWriter, r *http.Reques
 
 }
 
-// Handler for fecthing user information via email
+// Handler for fecthing user information via ID, or for the authenticated user via
+// /v1/users/me. Along with the user it returns their roles and assigned categories, so
+// that a client can show everything about the user from one request
 func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 
-	email, err := app.readEmailParam(r)
+	id, err := app.readUserParam(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	// Fetch user info from database
-	user, err := app.models.Users.UserGet(email, *r)
-	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
-			return
+	// Fetch user info from database, reading the user and their categories in one
+	// transaction so they are consistent with each other
+	var (
+		user       data.User
+		categories []*data.Category
+	)
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		user, err = tx.Users.UserGetID(r.Context(), int64(id))
+		if err != nil {
+			return err
 		}
+		categories, err = tx.UserCategories.UserCategoriesGet(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	roles, err := app.userRoles(r, user.ID)
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// All good? wrap and output user info
+	// All good? wrap and output user info, with an ETag for the version so that the
+	// client can make conditional updates
+	user.Picture = app.pictureURL(r, user.Picture)
+	w.Header().Set("ETag", etag(user.Version))
 	envelope := envelope{
-		"message": "user information",
-		"user":    user,
+		"message":    "user information",
+		"user":       user,
+		"roles":      roles,
+		"categories": categories,
 	}
 	err = app.writeJSON(w, http.StatusOK, envelope, nil)
 	if err != nil {
@@ -195,22 +244,36 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 
 	// / Create the input structure
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		Picture  string `json:"picture"`
+		Name            string  `json:"name"`
+		Email           string  `json:"email"`
+		Password        string  `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+		Picture         string  `json:"picture"`
 	}
 
 	// Read the request into the input struct
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	// get the email to be updated
-	email, err := app.readEmailParam(r)
+	// get the ID of the user to be updated
+	id, err := app.readUserParam(r)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Fetch the current version of the user, which the update is based on. If the client
+	// sent an If-Match header it must match this version.
+	current, err := app.models.Users.UserGetID(r.Context(), int64(id))
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	if !app.ifMatch(r, current.Version) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
@@ -224,39 +287,46 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 	// Validate the clients input
 	v := validator.New()
 
-	if data.ValidateUserRegisteration(v, user); !v.Valid() {
+	data.ValidateUserRegisteration(v, user, app.passwordPolicy)
+	// A PUT always replaces the password, so non-admins must confirm the current one
+	wait, err := app.checkCurrentPassword(r, v, current.Email, input.CurrentPassword)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+	if !v.Valid() {
 		// Validation failed, send appropriate error messages
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
+	user.Version = current.Version
 
-	// All good? update user information
-	err = app.models.Users.UserUpdate(*user, email)
-	if err != nil {
-		switch {
-		case err == data.ErrDuplicateEmail:
-			{
-				app.badRequestResponse(w, r, err)
-				return
-			}
-
-		case err == data.ErrRecordNotFound:
-			{
-				app.notFoundResponse(w, r)
-				return
-			}
-
+	// All good? update user information and fecth the updated data in one transaction.
+	// If the user changed since it was fetched above the update fails with an edit
+	// conflict. The transaction may be retried, so it only reads user and keeps the
+	// result to itself until it has committed
+	var updated data.User
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		if err := tx.Users.UserUpdate(r.Context(), *user, current.Email); err != nil {
+			return err
 		}
-	}
-
-	// fecth updated data
-	*user, err = app.models.Users.UserGet(user.Email, *r)
+		var err error
+		updated, err = tx.Users.UserGetID(r.Context(), int64(id))
+		return err
+	})
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
+	*user = updated
 
 	// Compose JSON reply
+	user.Picture = app.pictureURL(r, user.Picture)
+	w.Header().Set("ETag", etag(user.Version))
 	envelope := envelope{
 		"message": "updated information:",
 		"user":    user,
@@ -269,22 +339,228 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	// Get the Email to use for user lookup
-	email, err := app.readEmailParam(r)
+// Handler for partial updates, which only change the fields present in the request.
+// Unlike a PUT, the password is left alone unless one is given.
+func (app *application) patchUserHandler(w http.ResponseWriter, r *http.Request) {
+	// get the ID of the user to be updated
+	id, err := app.readUserParam(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	// Use email for query
-	err = app.models.Users.UserDelete(email)
+	// Fetch the existing user, which the supplied fields are applied to. Keep hold of
+	// their email, which the update looks them up by
+	user, err := app.models.Users.UserGetID(r.Context(), int64(id))
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	email := user.Email
+	if !app.ifMatch(r, user.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	// Use pointers for the fields so that we can tell a field which wasn't sent (nil)
+	// apart from one which was sent empty. The omitempty rule skips the fields which
+	// weren't sent, so only the supplied ones are validated
+	var input struct {
+		Name            *string `json:"name" validate:"omitempty,required"`
+		Email           *string `json:"email" validate:"omitempty,required,email"`
+		Password        *string `json:"password" validate:"omitempty,required"`
+		CurrentPassword *string `json:"current_password"`
+	}
+	err = app.readJSON(w, r, &input)
 	if err != nil {
-		if err == data.ErrRecordNotFound {
-			app.notFoundResponse(w, r)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Copy the supplied fields over the existing ones
+	v := validator.New()
+	v.Struct(&input)
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.Email != nil {
+		user.Email = *input.Email
+	}
+	if input.Password != nil {
+		// Check the password against the policy with the new name and email applied
+		user.Password = *input.Password
+		data.ValidatePasswordPlaintext(v, app.passwordPolicy, user.Password, &user)
+		wait, err := app.checkCurrentPassword(r, v, email, input.CurrentPassword)
+		if err != nil {
+			app.dataErrorResponse(w, r, err)
 			return
 		}
+		if wait > 0 {
+			app.loginLockedResponse(w, r, wait)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Save the changes, which fails with an edit conflict if the user changed since it
+	// was fetched, and fetch the result. The transaction may be retried, so it only reads
+	// user and keeps the result to itself until it has committed
+	var updated data.User
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		if err := tx.Users.UserUpdate(r.Context(), user, email); err != nil {
+			return err
+		}
+		var err error
+		updated, err = tx.Users.UserGetID(r.Context(), int64(id))
+		return err
+	})
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	user = updated
+
+	user.Picture = app.pictureURL(r, user.Picture)
+	w.Header().Set("ETag", etag(user.Version))
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "updated information:", "user": user}, nil)
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Handler for changing a password on its own, e.g. PUT /v1/users/me/password. Like a
+// PATCH with a password, users other than admins must send their current password
+func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Password        string  `json:"password"`
+		CurrentPassword *string `json:"current_password"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.UserGetID(r.Context(), int64(id))
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	if !app.ifMatch(r, user.Version) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, app.passwordPolicy, input.Password, &user)
+	wait, err := app.checkCurrentPassword(r, v, user.Email, input.CurrentPassword)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Users.UserUpdatePassword(r.Context(), user.Email, input.Password)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "password updated successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userRoles returns the names of the roles the user has, for clients deciding what to
+// offer them. Every user has the "user" role, and admins also have "admin".
+func (app *application) userRoles(r *http.Request, id int) ([]string, error) {
+	roles := []string{"user"}
+	isAdmin, err := app.models.Users.IsAdmin(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		roles = append(roles, "admin")
+	}
+	return roles, nil
+}
+
+// checkCurrentPassword is used by requests which change a password. Admins may set
+// anyone's password, but other users must also send their current password, so that a
+// stolen token isn't enough to take over the account. A missing or wrong password is
+// added to v as a validation error. A wrong password counts as a failed login, so the
+// check can't be used to guess it any faster than logging in; if the account or client
+// has to wait before trying again, the wait is returned instead of checking it.
+func (app *application) checkCurrentPassword(r *http.Request, v *validator.Validator, email string, current *string) (time.Duration, error) {
+	isAdmin, err := app.hasAdminRights(r, app.contextGetUser(r).ID)
+	if err != nil || isAdmin {
+		return 0, err
+	}
+	if current == nil || *current == "" {
+		v.AddError("current_password", "validation.current_password.required")
+		return 0, nil
+	}
+
+	attempt, wait, err := app.reserveLoginAttempt(r, email)
+	if err != nil || wait > 0 {
+		if wait > 0 {
+			app.audit(r, auditLoginBlocked, "email", email, "retry_after", wait.Round(time.Second).String())
+		}
+		return wait, err
+	}
+	defer app.releaseLoginAttempt(r, attempt)
+
+	match, err := app.models.Users.CheckPasswordMatches(r.Context(), data.User{Email: email}, *current)
+	if err != nil {
+		return 0, err
+	}
+	if !match {
+		app.loginFailed(r, attempt, "wrong_current_password")
+		v.AddError("current_password", "validation.current_password.wrong")
+	}
+	return 0, nil
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID to use for user lookup
+	id, err := app.readUserParam(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Look the user up by ID, then delete them. The If-Match check and the ones
+	// UserDelete makes before deleting run in the same transaction, so the user can't
+	// change in between
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		user, err := tx.Users.UserGetID(r.Context(), int64(id))
+		if err != nil {
+			return err
+		}
+		if !app.ifMatch(r, user.Version) {
+			return errPreconditionFailed
+		}
+		return tx.Users.UserDelete(r.Context(), user.Email)
+	})
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
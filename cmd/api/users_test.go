@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+)
+
+func TestCreateUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	valid := map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}
+
+	tests := []struct {
+		name     string
+		body     any
+		wantCode int
+	}{
+		{"Valid", valid, http.StatusCreated},
+		{"Duplicate email", valid, http.StatusConflict},
+		{"Missing name", map[string]string{"email": "bob@example.com", "password": "pa55word1234"}, http.StatusUnprocessableEntity},
+		{"Invalid email", map[string]string{"name": "Bob", "email": "bob", "password": "pa55word1234"}, http.StatusUnprocessableEntity},
+		{"Short password", map[string]string{"name": "Bob", "email": "bob@example.com", "password": "short"}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPost, "/v1/users", tt.body, "")
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCode  int
+		wantError string
+	}{
+		{"Breached", "password123", http.StatusUnprocessableEntity, "Password has appeared in a data breach, please choose another"},
+		{"Contains name", "alicewonder99", http.StatusUnprocessableEntity, "Password must not contain your name or email address"},
+		{"Too long for bcrypt", strings.Repeat("pa55word", 10), http.StatusUnprocessableEntity, "Password must not be longer than 72 bytes"},
+		{"Valid", "pa55word1234", http.StatusCreated, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := map[string]string{"name": "Alice Wonder", "email": "alice@example.com", "password": tt.password}
+			code, _, body := ts.do(t, http.MethodPost, "/v1/users", input, "")
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code != http.StatusUnprocessableEntity {
+				return
+			}
+
+			var resp struct {
+				Error            map[string]string `json:"error"`
+				PasswordStrength *struct {
+					Score int `json:"score"`
+				} `json:"password_strength"`
+			}
+			decodeJSON(t, body, &resp)
+			if resp.Error["password"] != tt.wantError {
+				t.Errorf("got error %q; want %q", resp.Error["password"], tt.wantError)
+			}
+			// Rejected passwords come with their strength, for a strength meter.
+			if resp.PasswordStrength == nil {
+				t.Error("got no password_strength")
+			}
+		})
+	}
+}
+
+func TestCreateUserRegistrationDisabled(t *testing.T) {
+	app := newTestApplication(t)
+	cfg := app.config
+	cfg.features = nil
+	app.runtime.Store(newRuntimeConfig(cfg))
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	_, userToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	tests := []struct {
+		name     string
+		email    string
+		token    string
+		wantCode int
+	}{
+		{"Anonymous", "bob@example.com", "", http.StatusForbidden},
+		{"Non-admin", "bob@example.com", userToken, http.StatusForbidden},
+		{"Admin", "bob@example.com", adminToken, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := map[string]string{"name": "Bob", "email": tt.email, "password": "pa55word1234"}
+			code, _, body := ts.do(t, http.MethodPost, "/v1/users", input, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+	_, bobToken := createTestUser(t, app, "Bob", "bob@example.com", false)
+
+	tests := []struct {
+		name     string
+		email    string
+		token    string
+		wantCode int
+	}{
+		{"Anonymous", alice.Email, "", http.StatusUnauthorized},
+		{"Other user", alice.Email, bobToken, http.StatusUnauthorized},
+		{"Same user", alice.Email, aliceToken, http.StatusOK},
+		{"Admin", alice.Email, adminToken, http.StatusOK},
+		{"Admin, missing user", "nobody@example.com", adminToken, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodGet, "/v1/users/"+tt.email, nil, tt.token)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+			if code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				User struct {
+					ID      int    `json:"id"`
+					Email   string `json:"email"`
+					Picture string `json:"picture"`
+				} `json:"user"`
+			}
+			decodeJSON(t, body, &resp)
+			if resp.User.ID != alice.ID || resp.User.Email != alice.Email {
+				t.Errorf("got user %+v; want id %d and email %q", resp.User, alice.ID, alice.Email)
+			}
+			if !strings.HasSuffix(resp.User.Picture, "/static/defaultpfp.jpeg") {
+				t.Errorf("got picture %q; want the default picture URL", resp.User.Picture)
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	createTestUser(t, app, "Bob", "bob@example.com", false)
+	_, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	tests := []struct {
+		name     string
+		body     map[string]string
+		wantCode int
+	}{
+		{"Invalid", map[string]string{"name": "", "email": "alice@example.com", "password": "pa55word1234", "current_password": "pa55word1234"}, http.StatusUnprocessableEntity},
+		{"Missing current password", map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}, http.StatusUnprocessableEntity},
+		{"Wrong current password", map[string]string{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234", "current_password": "wrongpassword"}, http.StatusUnprocessableEntity},
+		{"Duplicate email", map[string]string{"name": "Alice", "email": "bob@example.com", "password": "pa55word1234", "current_password": "pa55word1234"}, http.StatusConflict},
+		{"Valid", map[string]string{"name": "Alice Smith", "email": "alice@example.com", "password": "newpa55word1234", "current_password": "pa55word1234"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPut, "/v1/users/alice@example.com", tt.body, aliceToken)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	code, header, body := ts.do(t, http.MethodGet, "/v1/users/alice@example.com", nil, aliceToken)
+	if code != http.StatusOK || !strings.Contains(string(body), "Alice Smith") {
+		t.Errorf("got status %d and body %s; want the updated name", code, body)
+	}
+	// Only the valid update changed the user, so it's at version 2.
+	if got := header.Get("ETag"); got != `"2"` {
+		t.Errorf("got ETag %q; want %q", got, `"2"`)
+	}
+
+	update := map[string]string{"name": "Alice Jones", "email": "alice@example.com", "password": "newpa55word1234", "current_password": "newpa55word1234"}
+	code, _, body = ts.doWithHeader(t, http.MethodPut, "/v1/users/alice@example.com", update, aliceToken, http.Header{"If-Match": {`"1"`}})
+	if code != http.StatusPreconditionFailed {
+		t.Errorf("got status %d; want %d for a stale If-Match (body %s)", code, http.StatusPreconditionFailed, body)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	createTestUser(t, app, "Bob", "bob@example.com", false)
+	_, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	tests := []struct {
+		name     string
+		token    string
+		body     map[string]string
+		wantCode int
+	}{
+		{"Name only", aliceToken, map[string]string{"name": "Alice Smith"}, http.StatusOK},
+		{"Blank name", aliceToken, map[string]string{"name": ""}, http.StatusUnprocessableEntity},
+		{"Invalid email", aliceToken, map[string]string{"email": "not-an-email"}, http.StatusUnprocessableEntity},
+		{"Duplicate email", aliceToken, map[string]string{"email": "bob@example.com"}, http.StatusConflict},
+		{"Password without current password", aliceToken, map[string]string{"password": "newpa55word1234"}, http.StatusUnprocessableEntity},
+		{"Password with wrong current password", aliceToken, map[string]string{"password": "newpa55word1234", "current_password": "wrongpassword"}, http.StatusUnprocessableEntity},
+		{"Password with current password", aliceToken, map[string]string{"password": "newpa55word1234", "current_password": "pa55word1234"}, http.StatusOK},
+		{"Admin sets password", adminToken, map[string]string{"password": "adminpa55word1234"}, http.StatusOK},
+		{"Unknown field", aliceToken, map[string]string{"nickname": "Al"}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodPatch, "/v1/users/alice@example.com", tt.body, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	// The name change stuck, and the password is the one the admin set.
+	user, err := app.models.Users.UserGet(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Alice Smith" {
+		t.Errorf("got name %q; want %q", user.Name, "Alice Smith")
+	}
+	if match, err := app.models.Users.CheckPasswordMatches(context.Background(), user, "adminpa55word1234"); err != nil || !match {
+		t.Errorf("checking the password returned %t, %v; want a match", match, err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	createTestUser(t, app, "Alice", "alice@example.com", false)
+	_, bobToken := createTestUser(t, app, "Bob", "bob@example.com", false)
+	carol, _ := createTestUser(t, app, "Carol", "carol@example.com", false)
+
+	categoryID, err := app.models.Categories.CategoryCreate(context.Background(), data.Category{Name: "Books"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.UserCategories.InsertUserCategories(context.Background(), carol.ID, categoryID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		token    string
+		wantCode int
+	}{
+		{"Other user", "alice@example.com", bobToken, http.StatusUnauthorized},
+		{"Admin", "alice@example.com", adminToken, http.StatusOK},
+		{"Same user", "bob@example.com", bobToken, http.StatusOK},
+		{"Missing user", "nobody@example.com", adminToken, http.StatusNotFound},
+		{"Admin user", "admin@example.com", adminToken, http.StatusConflict},
+		{"User with categories", "carol@example.com", adminToken, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.do(t, http.MethodDelete, "/v1/users/"+tt.email, nil, tt.token)
+			if code != tt.wantCode {
+				t.Errorf("got status %d; want %d (body %s)", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	code, _, _ := ts.do(t, http.MethodGet, "/v1/users/alice@example.com", nil, adminToken)
+	if code != http.StatusNotFound {
+		t.Errorf("got status %d for deleted user; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestInsertImage(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+
+	t.Run("Not an image", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodPut, "/v1/users/alice@example.com/pfpicture", "just some text", aliceToken)
+		if code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d; want %d (body %s)", code, http.StatusUnprocessableEntity, body)
+		}
+	})
+
+	t.Run("PNG", func(t *testing.T) {
+		png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+		code, _, body := ts.do(t, http.MethodPut, "/v1/users/alice@example.com/pfpicture", png, aliceToken)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+		}
+		// Pictures are named after the user's name and ID.
+		fName := fmt.Sprintf("%s%d.png", alice.Name, alice.ID)
+		if !strings.Contains(string(body), "/static/"+fName) {
+			t.Errorf("response %s doesn't contain the new picture URL", body)
+		}
+
+		saved, err := os.ReadFile(filepath.Join(app.config.pictureDir, fName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(saved) != string(png) {
+			t.Errorf("saved picture is %q; want %q", saved, png)
+		}
+	})
+}
+
+func TestUserRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	admin, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+	bob, _ := createTestUser(t, app, "Bob", "bob@example.com", false)
+
+	// Don't follow redirects, so that the tests can check where they point.
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		token        string
+		wantCode     int
+		wantID       int
+		wantLocation string
+	}{
+		{"By ID", fmt.Sprintf("/v1/users/%d", alice.ID), aliceToken, http.StatusOK, alice.ID, ""},
+		{"Me", "/v1/users/me", aliceToken, http.StatusOK, alice.ID, ""},
+		{"Admin, me", "/v1/users/me", adminToken, http.StatusOK, admin.ID, ""},
+		{"Admin, other user", fmt.Sprintf("/v1/users/%d", bob.ID), adminToken, http.StatusOK, bob.ID, ""},
+		{"Other user", fmt.Sprintf("/v1/users/%d", bob.ID), aliceToken, http.StatusUnauthorized, 0, ""},
+		{"Anonymous", "/v1/users/me", "", http.StatusUnauthorized, 0, ""},
+		{"Invalid ID", "/v1/users/abc", aliceToken, http.StatusNotFound, 0, ""},
+		{"Admin, missing user", "/v1/users/99", adminToken, http.StatusNotFound, 0, ""},
+		{"Own email", "/v1/users/alice@example.com", aliceToken, http.StatusPermanentRedirect, 0, fmt.Sprintf("/v1/users/%d", alice.ID)},
+		{"Own email, subresource", "/v1/users/alice@example.com/pfpicture", aliceToken, http.StatusPermanentRedirect, 0, fmt.Sprintf("/v1/users/%d/pfpicture", alice.ID)},
+		{"Other user's email", "/v1/users/bob@example.com", aliceToken, http.StatusUnauthorized, 0, ""},
+		{"Admin, other user's email", "/v1/users/bob@example.com", adminToken, http.StatusPermanentRedirect, 0, fmt.Sprintf("/v1/users/%d", bob.ID)},
+		{"Admin, missing email", "/v1/users/nobody@example.com", adminToken, http.StatusNotFound, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodGet
+			if strings.HasSuffix(tt.urlPath, "/pfpicture") {
+				method = http.MethodPut
+			}
+			req, err := http.NewRequest(method, ts.URL+tt.urlPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rs, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Fatalf("got status %d; want %d (body %s)", rs.StatusCode, tt.wantCode, body)
+			}
+			if got := rs.Header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("got Location %q; want %q", got, tt.wantLocation)
+			}
+			if tt.wantID != 0 {
+				var resp struct {
+					User data.User `json:"user"`
+				}
+				decodeJSON(t, body, &resp)
+				if resp.User.ID != tt.wantID {
+					t.Errorf("got user %d; want %d", resp.User.ID, tt.wantID)
+				}
+			}
+		})
+	}
+}
+
+func TestMeEndpoints(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	_, adminToken := createTestUser(t, app, "Admin", "admin@example.com", true)
+	alice, aliceToken := createTestUser(t, app, "Alice", "alice@example.com", false)
+	_, bobToken := createTestUser(t, app, "Bob", "bob@example.com", false)
+
+	ctx := context.Background()
+	categoryID, err := app.models.Categories.CategoryCreate(ctx, data.Category{Name: "Books"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.UserCategories.InsertUserCategories(ctx, alice.ID, categoryID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		for _, tt := range []struct {
+			token          string
+			wantRoles      []string
+			wantCategories int
+		}{
+			{aliceToken, []string{"user"}, 1},
+			{adminToken, []string{"user", "admin"}, 0},
+		} {
+			code, _, body := ts.do(t, http.MethodGet, "/v1/users/me", nil, tt.token)
+			if code != http.StatusOK {
+				t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+			}
+			var resp struct {
+				Roles      []string        `json:"roles"`
+				Categories []data.Category `json:"categories"`
+			}
+			decodeJSON(t, body, &resp)
+			if strings.Join(resp.Roles, ",") != strings.Join(tt.wantRoles, ",") {
+				t.Errorf("got roles %v; want %v", resp.Roles, tt.wantRoles)
+			}
+			if len(resp.Categories) != tt.wantCategories {
+				t.Errorf("got categories %v; want %d", resp.Categories, tt.wantCategories)
+			}
+		}
+	})
+
+	t.Run("Categories", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodGet, "/v1/users/me/categories", nil, aliceToken)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+		}
+		var resp struct {
+			Categories []data.Category `json:"categories"`
+		}
+		decodeJSON(t, body, &resp)
+		if len(resp.Categories) != 1 || resp.Categories[0].ID != categoryID {
+			t.Errorf("got categories %v; want only %d", resp.Categories, categoryID)
+		}
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			body     map[string]string
+			wantCode int
+		}{
+			{"Missing current password", map[string]string{"password": "newpa55word1234"}, http.StatusUnprocessableEntity},
+			{"Too short", map[string]string{"password": "short", "current_password": "pa55word1234"}, http.StatusUnprocessableEntity},
+			{"Valid", map[string]string{"password": "newpa55word1234", "current_password": "pa55word1234"}, http.StatusOK},
+		}
+		for _, tt := range tests {
+			code, _, body := ts.do(t, http.MethodPut, "/v1/users/me/password", tt.body, aliceToken)
+			if code != tt.wantCode {
+				t.Errorf("%s: got status %d; want %d (body %s)", tt.name, code, tt.wantCode, body)
+			}
+		}
+		if match, err := app.models.Users.CheckPasswordMatches(ctx, alice, "newpa55word1234"); err != nil || !match {
+			t.Errorf("checking the new password returned %t, %v; want a match", match, err)
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodPatch, "/v1/users/me", map[string]string{"name": "Alice Smith"}, aliceToken)
+		if code != http.StatusOK || !strings.Contains(string(body), "Alice Smith") {
+			t.Errorf("got status %d and body %s; want the updated name", code, body)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		code, _, body := ts.do(t, http.MethodDelete, "/v1/users/me", nil, bobToken)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d; want %d (body %s)", code, http.StatusOK, body)
+		}
+		if _, err := app.models.Users.UserGet(ctx, "bob@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("getting the deleted user returned %v; want ErrRecordNotFound", err)
+		}
+	})
+}
+
+func TestCurrentPasswordLockout(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.login.lockoutEnabled = true
+	app.config.login.maxAccountFailures = 3
+	app.config.login.maxClientFailures = 100
+	app.config.login.lockoutDuration = time.Hour
+	ts := newTestServer(t, app.routes())
+
+	_, token := createTestUser(t, app, "Alice", "alice@example.com", false)
+	change := func(current string) (int, http.Header) {
+		code, header, _ := ts.do(t, http.MethodPut, "/v1/users/me/password", map[string]string{"password": "newpa55word1234", "current_password": current}, token)
+		return code, header
+	}
+
+	// A wrong current password counts as a failed login, so the account has to wait
+	// before the next attempt, even with the right password, and so does logging in.
+	if code, _ := change("wrongpassword"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d for a wrong current password; want %d", code, http.StatusUnprocessableEntity)
+	}
+	code, header := change("pa55word1234")
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d for the right current password while waiting; want %d", code, http.StatusTooManyRequests)
+	}
+	if header.Get("Retry-After") == "" {
+		t.Error("got no Retry-After header")
+	}
+	login := map[string]string{"email": "alice@example.com", "password": "pa55word1234"}
+	if code, _, _ := ts.do(t, http.MethodPost, "/v1/tokens/authentication", login, ""); code != http.StatusTooManyRequests {
+		t.Errorf("got status %d logging in while waiting; want %d", code, http.StatusTooManyRequests)
+	}
+}
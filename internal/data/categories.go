@@ -4,60 +4,53 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"time"
 
 	"interview_assignment.mohamednaas.net/internal/validator"
 )
 
-var (
-	ErrDuplicateCategoryName = errors.New("duplicate category name")
-)
-
 // the Category model used for connecting category info with the databse
 type CategoryModel struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
 }
 
 type Category struct {
-	Name string `json:"name"`
+	Name string `json:"name" validate:"required"`
 	ID   int    `json:"id"`
+	// Version starts at 1 and is incremented every time the category is updated.
+	Version int `json:"version"`
 }
 
 func ValidateCategoryInsertion(v *validator.Validator, c *Category) {
-	v.Check(validator.NotBlank(c.Name), "name", "Name must be provided")
+	v.Struct(c)
 }
 
 // Categoty insertion
-func (m *CategoryModel) CategoryCreate(c Category) (int, error) {
+func (m *CategoryModel) CategoryCreate(ctx context.Context, c Category) (int, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "CategoryCreate")
+	defer cancel()
+
 	// prepare query
 	q := "INSERT INTO categories (name) VALUES ($1) RETURNING id"
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	// If the table already contains a record with this email address, then when we try
-	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
-	// constraint that we set up in the previous chapter. We check for this error
-	// specifically, and return custom ErrDuplicateEmail error instead.
+	// If the table already contains a category with this name, then the insert violates
+	// the UNIQUE "categories_name_key" constraint, which translateError() turns into
+	// ErrDuplicateCategoryName.
 	err := m.DB.QueryRowContext(ctx, q, c.Name).Scan(&c.ID)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "categories_name_key"`:
-			return 0, ErrDuplicateCategoryName
-		default:
-			return 0, err
-		}
+		return 0, translateError(err)
 	}
 	return c.ID, nil
 }
 
 // fetch all categories
-func (m *CategoryModel) CategoriesGet() ([]*Category, error) {
+func (m *CategoryModel) CategoriesGet(ctx context.Context) ([]*Category, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "CategoriesGet")
+	defer cancel()
+
 	// Prepare query
-	q := `SELECT * FROM categories ORDER BY id`
+	q := `SELECT id, name, version FROM categories ORDER BY id`
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 	// Use QueryContext() to execute the query. This returns a sql.Rows resultset
 	// containing the result.
 	rows, err := m.DB.QueryContext(ctx, q)
@@ -77,6 +70,7 @@ func (m *CategoryModel) CategoriesGet() ([]*Category, error) {
 		err := rows.Scan(
 			&c.ID,
 			&c.Name,
+			&c.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -94,41 +88,56 @@ func (m *CategoryModel) CategoriesGet() ([]*Category, error) {
 	return categories, nil
 }
 
-func (m *CategoryModel) CategoryGet(id int) (Category, error) {
+func (m *CategoryModel) CategoryGet(ctx context.Context, id int) (Category, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "CategoryGet")
+	defer cancel()
+
 	c := Category{}
-	q := `SELECT name, id FROM categories WHERE id = $1`
+	q := `SELECT name, id, version FROM categories WHERE id = $1`
 
-	err := m.DB.QueryRow(q, id).Scan(&c.Name, &c.ID)
+	err := m.DB.QueryRowContext(ctx, q, id).Scan(&c.Name, &c.ID, &c.Version)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c, ErrRecordNotFound
-		}
-		return c, err
+		return c, translateError(err)
 	}
 	return c, nil
 }
 
-// Category Update, use ID to find it
-func (m *CategoryModel) CategoryUpdate(c Category) error {
-	// prepare query
-	q := `UPDATE categories SET name = $1 WHERE id = $2 RETURNING id`
+// Category Update, use ID to find it. c.Version must be the version the update is based
+// on, and is set to the new version afterwards. If the category has been changed in
+// the meantime nothing is updated and ErrEditConflict is returned.
+func (m *CategoryModel) CategoryUpdate(ctx context.Context, c *Category) error {
+	ctx, cancel := m.Timeouts.context(ctx, "CategoryUpdate")
+	defer cancel()
 
-	// excecute the query
-	err := m.DB.QueryRow(q, c.Name, c.ID).Scan(&c.ID)
+	// prepare query
+	q := `UPDATE categories SET name = $1, version = version + 1
+	WHERE id = $2 AND version = $3
+	RETURNING version`
+
+	// excecute the query, ErrNoRows means there's no category with the id at that
+	// version
+	err := m.DB.QueryRowContext(ctx, q, c.Name, c.ID, c.Version).Scan(&c.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return editConflict(ctx, m.DB, `SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, c.ID)
+	}
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "categories_name_key"`:
-			return ErrDuplicateCategoryName
-		default:
-			return err
-		}
+		return translateError(err)
 	}
 	return nil
 }
 
-// Category Delete by id
-func (m *CategoryModel) CategoryDelete(id int) {
-	// excecute query
-	m.DB.QueryRow("DELETE FROM categories WHERE id = $1", id)
+// Category Delete by id. A category which is still assigned to a user can't be deleted.
+func (m *CategoryModel) CategoryDelete(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "CategoryDelete")
+	defer cancel()
 
+	// excecute query
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM categories WHERE id = $1", id)
+	if err != nil {
+		if errors.Is(translateError(err), ErrForeignKeyViolation) {
+			return ErrRecordInUse
+		}
+		return translateError(err)
+	}
+	return expectRows(result)
 }
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Dialect identifies the SQL database behind the models. The queries in this package
+// are written in the subset of SQL which PostgreSQL and SQLite share (numbered $N
+// placeholders, RETURNING, CAST), so the dialect mostly matters for opening the
+// database, picking the migrations and recognising driver errors.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// DataSource describes how to open a database: the dialect, the name of the
+// database/sql driver and the DSN in the form that driver expects.
+type DataSource struct {
+	Dialect Dialect
+	Driver  string
+	DSN     string
+}
+
+// sqlitePragmas are set on every SQLite connection unless the DSN sets them itself.
+// SQLite doesn't enforce foreign keys by default, and without a busy timeout
+// concurrent writers fail immediately instead of waiting for the lock.
+var sqlitePragmas = []string{"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"}
+
+// ParseDSN selects the database by the scheme of dsn:
+//
+//	postgres://... or postgresql://...  PostgreSQL URL
+//	host=... dbname=...                 PostgreSQL key/value DSN
+//	sqlite:path/to/file.db              SQLite file, relative or absolute
+//	sqlite:///abs/path/file.db          SQLite file, absolute
+func ParseDSN(dsn string) (DataSource, error) {
+	scheme, rest, found := strings.Cut(dsn, ":")
+	if !found || strings.Contains(scheme, "=") {
+		// lib/pq also accepts "key=value" DSNs, which have no scheme.
+		if strings.Contains(dsn, "=") {
+			return DataSource{Dialect: Postgres, Driver: "postgres", DSN: dsn}, nil
+		}
+		return DataSource{}, errors.New("DSN must start with postgres://, postgresql:// or sqlite:")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return DataSource{Dialect: Postgres, Driver: "postgres", DSN: dsn}, nil
+	case "sqlite", "sqlite3":
+		return parseSQLiteDSN(rest)
+	default:
+		return DataSource{}, fmt.Errorf("unsupported database %q, use postgres or sqlite", scheme)
+	}
+}
+
+// parseSQLiteDSN turns the part of a sqlite: DSN after the scheme into a file: URI
+// for the SQLite driver, adding the default pragmas.
+func parseSQLiteDSN(rest string) (DataSource, error) {
+	path, rawQuery, _ := strings.Cut(rest, "?")
+	// sqlite:///abs/file.db and sqlite://file.db are accepted as well as sqlite:file.db.
+	if strings.HasPrefix(path, "//") {
+		path = strings.TrimPrefix(path, "//")
+	}
+	if path == "" {
+		return DataSource{}, errors.New("sqlite DSN must include a file path")
+	}
+	// Every connection to :memory: gets its own empty database, which doesn't work
+	// with a connection pool.
+	if path == ":memory:" {
+		return DataSource{}, errors.New("in-memory SQLite databases are not supported, use a file")
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return DataSource{}, fmt.Errorf("sqlite DSN: %w", err)
+	}
+	// Take the write lock when a transaction begins rather than at its first write. A
+	// deferred transaction which reads before writing fails with SQLITE_BUSY if another
+	// connection wrote in between, instead of waiting for the busy timeout.
+	if query.Get("_txlock") == "" {
+		query.Set("_txlock", "immediate")
+	}
+	for _, pragma := range sqlitePragmas {
+		name, _, _ := strings.Cut(pragma, "(")
+		set := false
+		for _, existing := range query["_pragma"] {
+			if strings.HasPrefix(strings.ToLower(existing), name) {
+				set = true
+			}
+		}
+		if !set {
+			query.Add("_pragma", pragma)
+		}
+	}
+
+	return DataSource{Dialect: SQLite, Driver: "sqlite", DSN: "file:" + path + "?" + query.Encode()}, nil
+}
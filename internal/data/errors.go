@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The kinds of error returned by the models. Handlers check for these with errors.Is()
+// rather than for the more specific errors below, so that a new constraint doesn't need
+// a new case in every handler.
+var (
+	// ErrRecordNotFound is returned when the record being fetched, updated or deleted
+	// doesn't exist.
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrConflict is returned when a change would clash with an existing record, such
+	// as a duplicate value in a unique column.
+	ErrConflict = errors.New("conflicts with an existing record")
+	// ErrForeignKeyViolation is returned when a record refers to another record which
+	// doesn't exist.
+	ErrForeignKeyViolation = errors.New("refers to a record which does not exist")
+	// ErrCheckViolation is returned when a value is rejected by a CHECK or NOT NULL
+	// constraint.
+	ErrCheckViolation = errors.New("value is not allowed")
+)
+
+// The specific errors for the constraints the handlers report to clients. Each one is
+// also of one of the kinds above, e.g. errors.Is(ErrDuplicateEmail, ErrConflict).
+var (
+	ErrDuplicateEmail          = newKindError("duplicate email", ErrConflict)
+	ErrDuplicateCategoryName   = newKindError("duplicate category name", ErrConflict)
+	ErrCategoryAlreadyAssigned = newKindError("category is already assigned to a user", ErrConflict)
+	ErrRecordInUse             = newKindError("record is still referenced by other records", ErrConflict)
+	ErrCannotDeleteAdmin       = newKindError("cannot delete admins", ErrConflict)
+	ErrEditConflict            = newKindError("edit conflict", ErrConflict)
+)
+
+// constraintErrors maps constraint names to the specific error returned when the
+// constraint is violated. The names are the ones PostgreSQL generates; SQLite's are
+// translated to match in constraintViolation().
+var constraintErrors = map[string]error{
+	"users_email_key":                 ErrDuplicateEmail,
+	"categories_name_key":             ErrDuplicateCategoryName,
+	"user_categories_category_id_key": ErrCategoryAlreadyAssigned,
+}
+
+// kindError is a specific error which also matches the kind of error it belongs to.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func newKindError(msg string, kind error) error {
+	return &kindError{msg: msg, kind: kind}
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// ConstraintError is returned for a constraint violation which has no specific error in
+// constraintErrors. It matches its Kind with errors.Is(), and unwraps to the driver's
+// error.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	Err        error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%v (constraint %q): %v", e.Kind, e.Constraint, e.Err)
+}
+
+func (e *ConstraintError) Is(target error) bool { return target == e.Kind }
+
+func (e *ConstraintError) Unwrap() error { return e.Err }
+
+// translateError turns an error from database/sql or the driver into one of the errors
+// above: sql.ErrNoRows becomes ErrRecordNotFound, and constraint violations become the
+// error registered for the constraint, or a ConstraintError of the right kind. Any
+// other error is returned unchanged.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+
+	kind, constraint, ok := constraintViolation(err)
+	if !ok {
+		return err
+	}
+	if specific, ok := constraintErrors[constraint]; ok {
+		return specific
+	}
+	return &ConstraintError{Kind: kind, Constraint: constraint, Err: err}
+}
+
+// constraintViolation reports whether err is a constraint violation, returning its kind
+// and the name of the constraint. PostgreSQL errors are classified by SQLSTATE, and
+// SQLite errors by their extended result code.
+func constraintViolation(err error) (kind error, constraint string, ok bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505": // unique_violation
+			return ErrConflict, pqErr.Constraint, true
+		case "23503": // foreign_key_violation
+			return ErrForeignKeyViolation, pqErr.Constraint, true
+		case "23514": // check_violation
+			return ErrCheckViolation, pqErr.Constraint, true
+		case "23502": // not_null_violation
+			return ErrCheckViolation, pqErr.Column, true
+		}
+		return nil, "", false
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// SQLite doesn't name column constraints. The message ends with the columns
+		// instead, e.g. "UNIQUE constraint failed: users.email (2067)", which is turned
+		// into the name PostgreSQL would use.
+		var detail string
+		if i := strings.LastIndex(sqliteErr.Error(), "failed: "); i >= 0 {
+			detail, _, _ = strings.Cut(sqliteErr.Error()[i+len("failed: "):], " (")
+		}
+		name := strings.ReplaceAll(detail, ".", "_")
+
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+			return ErrConflict, name + "_key", true
+		case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			table, _, _ := strings.Cut(detail, ".")
+			return ErrConflict, table + "_pkey", true
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			// SQLite doesn't say which foreign key failed.
+			return ErrForeignKeyViolation, "", true
+		case sqlite3.SQLITE_CONSTRAINT_CHECK:
+			return ErrCheckViolation, detail, true
+		case sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+			return ErrCheckViolation, name, true
+		}
+	}
+
+	return nil, "", false
+}
+
+// isRetryable reports whether err means a transaction failed because of the
+// transactions running alongside it, so that trying it again is likely to succeed:
+// a serialization failure or deadlock in PostgreSQL, or a busy or locked database in
+// SQLite.
+func isRetryable(err error) bool {
+	if errors.Is(err, errTxConflict) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || // serialization_failure
+			pqErr.Code == "40P01" // deadlock_detected
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// The extended result codes, e.g. SQLITE_BUSY_SNAPSHOT, keep the primary code
+		// in the low byte.
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+	}
+
+	return false
+}
+
+// expectRows returns ErrRecordNotFound if an UPDATE or DELETE didn't affect any rows,
+// meaning the record it targeted doesn't exist.
+func expectRows(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// editConflict is called when an UPDATE conditional on a record's version matched no
+// rows. It runs the query exists, which must select whether the record is still there,
+// and returns ErrEditConflict if it is (so its version must have changed) and
+// ErrRecordNotFound if it isn't.
+func editConflict(ctx context.Context, db DBTX, exists string, args ...any) error {
+	var found bool
+	if err := db.QueryRowContext(ctx, exists, args...).Scan(&found); err != nil {
+		return translateError(err)
+	}
+	if found {
+		return ErrEditConflict
+	}
+	return ErrRecordNotFound
+}
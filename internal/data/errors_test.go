@@ -0,0 +1,50 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslateError(t *testing.T) {
+	other := errors.New("connection refused")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"No rows", sql.ErrNoRows, ErrRecordNotFound},
+		{"Wrapped no rows", fmt.Errorf("query: %w", sql.ErrNoRows), ErrRecordNotFound},
+		{"Known unique constraint", &pq.Error{Code: "23505", Constraint: "users_email_key"}, ErrDuplicateEmail},
+		{"Other unique constraint", &pq.Error{Code: "23505", Constraint: "admins_pkey"}, ErrConflict},
+		{"Foreign key", &pq.Error{Code: "23503", Constraint: "user_categories_user_id_fkey"}, ErrForeignKeyViolation},
+		{"Check", &pq.Error{Code: "23514", Constraint: "users_name_check"}, ErrCheckViolation},
+		{"Not null", &pq.Error{Code: "23502", Column: "name"}, ErrCheckViolation},
+		{"Other PostgreSQL error", &pq.Error{Code: "42P01"}, nil},
+		{"Other error", other, other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := translateError(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("got %v; want the error unchanged", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The specific errors also match their kind.
+	if !errors.Is(translateError(&pq.Error{Code: "23505", Constraint: "users_email_key"}), ErrConflict) {
+		t.Error("ErrDuplicateEmail doesn't match ErrConflict")
+	}
+}
@@ -0,0 +1,49 @@
+package data
+
+import "interview_assignment.mohamednaas.net/internal/validator"
+
+// Filters holds the pagination parameters for the list endpoints.
+type Filters struct {
+	Page     int `validate:"min=1,max=10000000"`
+	PageSize int `validate:"min=1,max=100"`
+}
+
+// ValidateFilters checks that the page and page_size parameters contain sensible values.
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Struct(f)
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Metadata holds the pagination details sent along with a page of records.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// The calculateMetadata() function calculates the appropriate pagination metadata
+// values given the total number of records, current page, and page size values. The
+// last page is the total divided by the page size, rounded up.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		// Note that we return an empty Metadata struct if there are no records.
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,99 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type LoginFailureModel struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+// LoginFailure counts the failed logins for a key, which names what failed: an account,
+// as "email:<address>", or a client, as "ip:<address>". Accounts are counted by the
+// email address given rather than by user, so that addresses without an account are
+// treated the same as those with one.
+type LoginFailure struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure_at"`
+}
+
+// AccountLoginKey returns the key failed logins for an email address are counted under.
+// Addresses differing only in case share a count.
+func AccountLoginKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// ClientLoginKey returns the key failed logins from an IP address are counted under.
+func ClientLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Getting the failed logins for a key. A key without any returns a LoginFailure with
+// no failures rather than ErrRecordNotFound, as that's the common case.
+func (m *LoginFailureModel) LoginFailureGet(ctx context.Context, key string) (LoginFailure, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "LoginFailureGet")
+	defer cancel()
+
+	failure := LoginFailure{Key: key}
+	q := `SELECT failures, last_failure_at FROM login_failures WHERE key = $1`
+	err := m.DB.QueryRowContext(ctx, q, key).Scan(&failure.Failures, &failure.LastFailure)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return failure, translateError(err)
+	}
+	return failure, nil
+}
+
+// Recording a failed login for a key at the given time, and returning the new count.
+// Failures before since are forgotten, so the count starts again from 1 if the last one
+// was before then.
+func (m *LoginFailureModel) LoginFailureRecord(ctx context.Context, key string, at, since time.Time) (LoginFailure, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "LoginFailureRecord")
+	defer cancel()
+
+	// The times are stored to the second in UTC, which SQLite also needs to compare
+	// them as text.
+	at, since = at.UTC().Truncate(time.Second), since.UTC().Truncate(time.Second)
+	q := `INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, $2)
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN login_failures.last_failure_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+		last_failure_at = $2
+	RETURNING failures`
+
+	failure := LoginFailure{Key: key, LastFailure: at}
+	err := m.DB.QueryRowContext(ctx, q, key, at, since).Scan(&failure.Failures)
+	if err != nil {
+		return failure, translateError(err)
+	}
+	return failure, nil
+}
+
+// Taking back one failed login for a key, for an attempt which was counted before it
+// was known whether it would fail, and then didn't. The time of the last failure is
+// left as it is.
+func (m *LoginFailureModel) LoginFailureRelease(ctx context.Context, key string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "LoginFailureRelease")
+	defer cancel()
+
+	q := `UPDATE login_failures SET failures = failures - 1 WHERE key = $1 AND failures > 0`
+	_, err := m.DB.ExecContext(ctx, q, key)
+	return translateError(err)
+}
+
+// Forgetting the failed logins for a key, after a successful login or to unlock it.
+// Returns ErrRecordNotFound if there weren't any.
+func (m *LoginFailureModel) LoginFailureDelete(ctx context.Context, key string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "LoginFailureDelete")
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE key = $1`, key)
+	if err != nil {
+		return translateError(err)
+	}
+	return expectRows(result)
+}
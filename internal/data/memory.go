@@ -0,0 +1,749 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/password"
+)
+
+// memoryStore holds the tables for the in-memory models. A single mutex guards all of
+// them, which is plenty for tests and keeps the models consistent with each other.
+type memoryStore struct {
+	mu             sync.Mutex
+	users          map[int]*memoryUser
+	admins         map[int]bool
+	categories     map[int]Category
+	userCategories map[int]memoryGrant // category ID -> assignment
+	loginFailures  map[string]LoginFailure
+	totp           map[int]TOTP
+	recoveryCodes  map[int]map[string]bool // user ID -> set of code hashes
+	nextUserID     int
+	nextCategoryID int
+	// version is incremented by every write, so that a transaction can tell whether
+	// anything changed since it took its snapshot.
+	version int
+	// hasher hashes new passwords.
+	hasher password.Hasher
+}
+
+// memoryGrant is a row of the user_categories table.
+type memoryGrant struct {
+	userID int
+	Grant
+}
+
+// memoryUser is a row of the users table, with the password hash kept separately from
+// the User struct as it is in the database.
+type memoryUser struct {
+	user User
+	hash []byte
+}
+
+// NewMemoryModels returns Models backed by in-memory maps instead of a database. They
+// return the same errors as the SQL models (ErrRecordNotFound, ErrDuplicateEmail,
+// ErrForeignKeyViolation etc.), which makes them suitable for testing handlers without
+// a database. Passwords are hashed with the given hasher.
+func NewMemoryModels(hasher password.Hasher) Models {
+	store := &memoryStore{
+		users:          make(map[int]*memoryUser),
+		admins:         make(map[int]bool),
+		categories:     make(map[int]Category),
+		userCategories: make(map[int]memoryGrant),
+		loginFailures:  make(map[string]LoginFailure),
+		totp:           make(map[int]TOTP),
+		recoveryCodes:  make(map[int]map[string]bool),
+		nextUserID:     1,
+		nextCategoryID: 1,
+		hasher:         hasher,
+	}
+	return store.models(&memoryTransactor{store})
+}
+
+// models returns the models for the store.
+func (s *memoryStore) models(tx transactor) Models {
+	return Models{
+		Users:          &memoryUserModel{s},
+		Categories:     &memoryCategoryModel{s},
+		UserCategories: &memoryUserCategoriesModel{s},
+		LoginFailures:  &memoryLoginFailureModel{s},
+		TOTP:           &memoryTOTPModel{s},
+		tx:             tx,
+	}
+}
+
+// clone returns a copy of the store. The caller must hold the lock.
+func (s *memoryStore) clone() *memoryStore {
+	c := &memoryStore{
+		users:          make(map[int]*memoryUser, len(s.users)),
+		admins:         make(map[int]bool, len(s.admins)),
+		categories:     make(map[int]Category, len(s.categories)),
+		userCategories: make(map[int]memoryGrant, len(s.userCategories)),
+		loginFailures:  make(map[string]LoginFailure, len(s.loginFailures)),
+		totp:           make(map[int]TOTP, len(s.totp)),
+		recoveryCodes:  make(map[int]map[string]bool, len(s.recoveryCodes)),
+		nextUserID:     s.nextUserID,
+		nextCategoryID: s.nextCategoryID,
+		version:        s.version,
+		hasher:         s.hasher,
+	}
+	for id, u := range s.users {
+		u := *u
+		c.users[id] = &u
+	}
+	for id, admin := range s.admins {
+		c.admins[id] = admin
+	}
+	for id, category := range s.categories {
+		c.categories[id] = category
+	}
+	for categoryID, grant := range s.userCategories {
+		c.userCategories[categoryID] = grant
+	}
+	for key, failure := range s.loginFailures {
+		c.loginFailures[key] = failure
+	}
+	for userID, t := range s.totp {
+		c.totp[userID] = t
+	}
+	for userID, codes := range s.recoveryCodes {
+		c.recoveryCodes[userID] = make(map[string]bool, len(codes))
+		for hash := range codes {
+			c.recoveryCodes[userID][hash] = true
+		}
+	}
+	return c
+}
+
+// memoryTransactor runs transactions against a snapshot of the store, which replaces
+// the store's tables when the transaction commits. If another write reached the store
+// in the meantime the transaction fails with errTxConflict and is retried, much like a
+// serialization failure in PostgreSQL.
+type memoryTransactor struct {
+	store *memoryStore
+}
+
+func (t *memoryTransactor) transaction(ctx context.Context, fn func(Models) error) error {
+	return retryTx(ctx, func() error {
+		t.store.mu.Lock()
+		snapshot := t.store.clone()
+		t.store.mu.Unlock()
+		base := snapshot.version
+
+		if err := fn(snapshot.models(nil)); err != nil {
+			return err
+		}
+
+		if snapshot.version == base {
+			// Nothing was written, so there is nothing to commit.
+			return nil
+		}
+
+		t.store.mu.Lock()
+		defer t.store.mu.Unlock()
+		if t.store.version != base {
+			return errTxConflict
+		}
+		t.store.users = snapshot.users
+		t.store.admins = snapshot.admins
+		t.store.categories = snapshot.categories
+		t.store.userCategories = snapshot.userCategories
+		t.store.loginFailures = snapshot.loginFailures
+		t.store.totp = snapshot.totp
+		t.store.recoveryCodes = snapshot.recoveryCodes
+		t.store.nextUserID = snapshot.nextUserID
+		t.store.nextCategoryID = snapshot.nextCategoryID
+		t.store.version = snapshot.version
+		return nil
+	})
+}
+
+type memoryUserModel struct {
+	store *memoryStore
+}
+
+// byEmail returns the user with the given email. The caller must hold the lock.
+func (m *memoryUserModel) byEmail(email string) *memoryUser {
+	for _, u := range m.store.users {
+		if u.user.Email == email {
+			return u
+		}
+	}
+	return nil
+}
+
+func (m *memoryUserModel) UserCreate(ctx context.Context, u User) (int, error) {
+	hash, err := hashPassword(ctx, m.store.hasher, u.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.byEmail(u.Email) != nil {
+		return 0, ErrDuplicateEmail
+	}
+	id := m.store.nextUserID
+	m.store.nextUserID++
+	m.store.users[id] = &memoryUser{
+		user: User{ID: id, Name: u.Name, Email: u.Email, Picture: "defaultpfp.jpeg", Version: 1},
+		hash: hash,
+	}
+	m.store.version++
+	return id, nil
+}
+
+func (m *memoryUserModel) UserGet(ctx context.Context, email string) (User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	u := m.byEmail(email)
+	if u == nil {
+		return User{}, ErrRecordNotFound
+	}
+	return u.user, nil
+}
+
+func (m *memoryUserModel) UserGetID(ctx context.Context, ID int64) (User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	u, ok := m.store.users[int(ID)]
+	if !ok {
+		return User{}, ErrRecordNotFound
+	}
+	return u.user, nil
+}
+
+func (m *memoryUserModel) UsersGet(ctx context.Context) ([]*User, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	users := []*User{}
+	for _, u := range m.store.users {
+		user := u.user
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (m *memoryUserModel) UserUpdatePicture(ctx context.Context, picture, email string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	u := m.byEmail(email)
+	if u == nil {
+		return ErrRecordNotFound
+	}
+	u.user.Picture = picture
+	u.user.Version++
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserModel) UserUpdate(ctx context.Context, u User, email string) error {
+	var hash []byte
+	if u.Password != "" {
+		var err error
+		hash, err = hashPassword(ctx, m.store.hasher, u.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	existing := m.byEmail(email)
+	if existing == nil {
+		return ErrRecordNotFound
+	}
+	if existing.user.Version != u.Version {
+		return ErrEditConflict
+	}
+	if other := m.byEmail(u.Email); other != nil && other != existing {
+		return ErrDuplicateEmail
+	}
+	existing.user.Email = u.Email
+	existing.user.Name = u.Name
+	if hash != nil {
+		existing.hash = hash
+	}
+	existing.user.Version++
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserModel) UserUpdatePassword(ctx context.Context, email, password string) error {
+	hash, err := hashPassword(ctx, m.store.hasher, password)
+	if err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	u := m.byEmail(email)
+	if u == nil {
+		return ErrRecordNotFound
+	}
+	u.hash = hash
+	u.user.Version++
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserModel) UserDelete(ctx context.Context, email string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	u := m.byEmail(email)
+	if u == nil {
+		return ErrRecordNotFound
+	}
+	if m.store.admins[u.user.ID] {
+		return ErrCannotDeleteAdmin
+	}
+	for _, grant := range m.store.userCategories {
+		if grant.userID == u.user.ID {
+			return ErrRecordInUse
+		}
+	}
+	delete(m.store.users, u.user.ID)
+	// Like ON DELETE CASCADE, remove their two-factor authentication secret and codes.
+	delete(m.store.totp, u.user.ID)
+	delete(m.store.recoveryCodes, u.user.ID)
+	// Like ON DELETE SET NULL, forget that the user granted any categories.
+	for categoryID, grant := range m.store.userCategories {
+		if grant.GrantedBy != nil && *grant.GrantedBy == u.user.ID {
+			grant.GrantedBy = nil
+			m.store.userCategories[categoryID] = grant
+		}
+	}
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserModel) CheckPasswordMatches(ctx context.Context, u User, pass string) (bool, error) {
+	m.store.mu.Lock()
+	existing := m.byEmail(u.Email)
+	m.store.mu.Unlock()
+
+	if existing == nil {
+		return false, ErrRecordNotFound
+	}
+	return comparePassword(ctx, pass, existing.hash)
+}
+
+func (m *memoryUserModel) UserRehashPassword(ctx context.Context, u User, pass string) (bool, error) {
+	m.store.mu.Lock()
+	existing := m.byEmail(u.Email)
+	var hash []byte
+	if existing != nil {
+		hash = existing.hash
+	}
+	m.store.mu.Unlock()
+
+	if existing == nil {
+		return false, ErrRecordNotFound
+	}
+	if !m.store.hasher.NeedsRehash(hash) {
+		return false, nil
+	}
+	newHash, err := hashPassword(ctx, m.store.hasher, pass)
+	if err != nil {
+		return false, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// Leave the hash alone if the password was changed while rehashing.
+	existing = m.byEmail(u.Email)
+	if existing == nil || !bytes.Equal(existing.hash, hash) {
+		return false, nil
+	}
+	existing.hash = newHash
+	m.store.version++
+	return true, nil
+}
+
+func (m *memoryUserModel) IsAdmin(ctx context.Context, id int) (bool, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return m.store.admins[id], nil
+}
+
+func (m *memoryUserModel) AdminGrant(ctx context.Context, id int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[id]; !ok {
+		return ErrForeignKeyViolation
+	}
+	m.store.admins[id] = true
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserModel) AdminRevoke(ctx context.Context, id int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	delete(m.store.admins, id)
+	m.store.version++
+	return nil
+}
+
+type memoryCategoryModel struct {
+	store *memoryStore
+}
+
+// nameTaken reports whether another category already uses name. The caller must hold
+// the lock.
+func (m *memoryCategoryModel) nameTaken(name string, id int) bool {
+	for _, c := range m.store.categories {
+		if c.Name == name && c.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memoryCategoryModel) CategoryCreate(ctx context.Context, c Category) (int, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.nameTaken(c.Name, 0) {
+		return 0, ErrDuplicateCategoryName
+	}
+	c.ID = m.store.nextCategoryID
+	c.Version = 1
+	m.store.nextCategoryID++
+	m.store.categories[c.ID] = c
+	m.store.version++
+	return c.ID, nil
+}
+
+func (m *memoryCategoryModel) CategoriesGet(ctx context.Context) ([]*Category, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	categories := []*Category{}
+	for _, c := range m.store.categories {
+		c := c
+		categories = append(categories, &c)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
+func (m *memoryCategoryModel) CategoryGet(ctx context.Context, id int) (Category, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	c, ok := m.store.categories[id]
+	if !ok {
+		return Category{}, ErrRecordNotFound
+	}
+	return c, nil
+}
+
+func (m *memoryCategoryModel) CategoryUpdate(ctx context.Context, c *Category) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	existing, ok := m.store.categories[c.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if existing.Version != c.Version {
+		return ErrEditConflict
+	}
+	if m.nameTaken(c.Name, c.ID) {
+		return ErrDuplicateCategoryName
+	}
+	c.Version++
+	m.store.categories[c.ID] = *c
+	m.store.version++
+	return nil
+}
+
+func (m *memoryCategoryModel) CategoryDelete(ctx context.Context, id int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.categories[id]; !ok {
+		return ErrRecordNotFound
+	}
+	// Like the foreign key in the database, refuse to delete a category which is still
+	// assigned to a user.
+	if _, assigned := m.store.userCategories[id]; assigned {
+		return ErrRecordInUse
+	}
+	delete(m.store.categories, id)
+	m.store.version++
+	return nil
+}
+
+type memoryUserCategoriesModel struct {
+	store *memoryStore
+}
+
+func (m *memoryUserCategoriesModel) InsertUserCategories(ctx context.Context, userID, categoryID, grantedBy int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return ErrForeignKeyViolation
+	}
+	if _, ok := m.store.categories[categoryID]; !ok {
+		return ErrForeignKeyViolation
+	}
+	if _, ok := m.store.users[grantedBy]; grantedBy != 0 && !ok {
+		return ErrForeignKeyViolation
+	}
+	if _, assigned := m.store.userCategories[categoryID]; assigned {
+		return ErrCategoryAlreadyAssigned
+	}
+	grant := memoryGrant{userID: userID, Grant: Grant{GrantedAt: time.Now().UTC().Truncate(time.Second)}}
+	if grantedBy != 0 {
+		grant.GrantedBy = &grantedBy
+	}
+	m.store.userCategories[categoryID] = grant
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserCategoriesModel) DeleteUserCategories(ctx context.Context, userID, categoryID int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if grant, ok := m.store.userCategories[categoryID]; !ok || grant.userID != userID {
+		return ErrRecordNotFound
+	}
+	delete(m.store.userCategories, categoryID)
+	m.store.version++
+	return nil
+}
+
+func (m *memoryUserCategoriesModel) UserCategoriesGet(ctx context.Context, userId int) ([]*Category, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	categories := []*Category{}
+	for categoryID, grant := range m.store.userCategories {
+		if grant.userID == userId {
+			c := m.store.categories[categoryID]
+			categories = append(categories, &c)
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories, nil
+}
+
+func (m *memoryUserCategoriesModel) UserCategoryGrantsGet(ctx context.Context, userID int, filters Filters) ([]*CategoryGrant, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	grants := []*CategoryGrant{}
+	for categoryID, grant := range m.store.userCategories {
+		if grant.userID == userID {
+			grants = append(grants, &CategoryGrant{Category: m.store.categories[categoryID], Grant: grant.Grant})
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool { return grants[i].ID < grants[j].ID })
+	grants, metadata := paginate(grants, filters)
+	return grants, metadata, nil
+}
+
+func (m *memoryUserCategoriesModel) CategoryUsersGet(ctx context.Context, categoryID int, filters Filters) ([]*UserGrant, Metadata, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	grants := []*UserGrant{}
+	if grant, ok := m.store.userCategories[categoryID]; ok {
+		grants = append(grants, &UserGrant{User: m.store.users[grant.userID].user, Grant: grant.Grant})
+	}
+	grants, metadata := paginate(grants, filters)
+	return grants, metadata, nil
+}
+
+// paginate returns the page of records selected by filters, like LIMIT and OFFSET,
+// along with the metadata for it.
+func paginate[T any](records []T, filters Filters) ([]T, Metadata) {
+	metadata := calculateMetadata(len(records), filters.Page, filters.PageSize)
+	start := min(filters.offset(), len(records))
+	end := min(start+filters.limit(), len(records))
+	return records[start:end], metadata
+}
+
+type memoryLoginFailureModel struct {
+	store *memoryStore
+}
+
+func (m *memoryLoginFailureModel) LoginFailureGet(ctx context.Context, key string) (LoginFailure, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	failure, ok := m.store.loginFailures[key]
+	if !ok {
+		return LoginFailure{Key: key}, nil
+	}
+	return failure, nil
+}
+
+func (m *memoryLoginFailureModel) LoginFailureRecord(ctx context.Context, key string, at, since time.Time) (LoginFailure, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// Like the database, keep the times to the second.
+	at, since = at.UTC().Truncate(time.Second), since.UTC().Truncate(time.Second)
+	failure, ok := m.store.loginFailures[key]
+	if !ok || failure.LastFailure.Before(since) {
+		failure = LoginFailure{Key: key}
+	}
+	failure.Failures++
+	failure.LastFailure = at
+	m.store.loginFailures[key] = failure
+	m.store.version++
+	return failure, nil
+}
+
+func (m *memoryLoginFailureModel) LoginFailureRelease(ctx context.Context, key string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	failure, ok := m.store.loginFailures[key]
+	if !ok || failure.Failures == 0 {
+		return nil
+	}
+	failure.Failures--
+	m.store.loginFailures[key] = failure
+	m.store.version++
+	return nil
+}
+
+func (m *memoryLoginFailureModel) LoginFailureDelete(ctx context.Context, key string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.loginFailures[key]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.store.loginFailures, key)
+	m.store.version++
+	return nil
+}
+
+type memoryTOTPModel struct {
+	store *memoryStore
+}
+
+func (m *memoryTOTPModel) TOTPGet(ctx context.Context, userID int) (TOTP, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	t, ok := m.store.totp[userID]
+	if !ok {
+		return TOTP{UserID: userID}, ErrRecordNotFound
+	}
+	return t, nil
+}
+
+func (m *memoryTOTPModel) TOTPSet(ctx context.Context, userID int, secret string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return ErrForeignKeyViolation
+	}
+	if m.store.totp[userID].Confirmed {
+		return ErrConflict
+	}
+	m.store.totp[userID] = TOTP{UserID: userID, Secret: secret}
+	m.store.version++
+	return nil
+}
+
+func (m *memoryTOTPModel) TOTPConfirm(ctx context.Context, userID int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	t, ok := m.store.totp[userID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	t.Confirmed = true
+	m.store.totp[userID] = t
+	m.store.version++
+	return nil
+}
+
+func (m *memoryTOTPModel) TOTPUseCounter(ctx context.Context, userID int, counter int64) (bool, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	t, ok := m.store.totp[userID]
+	if !ok || t.LastCounter >= counter {
+		return false, nil
+	}
+	t.LastCounter = counter
+	m.store.totp[userID] = t
+	m.store.version++
+	return true, nil
+}
+
+func (m *memoryTOTPModel) TOTPDelete(ctx context.Context, userID int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.totp[userID]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.store.totp, userID)
+	delete(m.store.recoveryCodes, userID)
+	m.store.version++
+	return nil
+}
+
+func (m *memoryTOTPModel) RecoveryCodesReplace(ctx context.Context, userID int, hashes [][]byte) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.totp[userID]; !ok && len(hashes) > 0 {
+		return ErrForeignKeyViolation
+	}
+	codes := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		codes[string(hash)] = true
+	}
+	m.store.recoveryCodes[userID] = codes
+	m.store.version++
+	return nil
+}
+
+func (m *memoryTOTPModel) RecoveryCodeUse(ctx context.Context, userID int, hash []byte) (bool, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if !m.store.recoveryCodes[userID][string(hash)] {
+		return false, nil
+	}
+	delete(m.store.recoveryCodes[userID], string(hash))
+	m.store.version++
+	return true, nil
+}
+
+func (m *memoryTOTPModel) RecoveryCodesCount(ctx context.Context, userID int) (int, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return len(m.store.recoveryCodes[userID]), nil
+}
@@ -1,27 +1,107 @@
 package data
 
 import (
+	"context"
 	"database/sql"
-	"errors"
-)
+	"time"
 
-// Define a custom ErrRecordNotFound error.
-var (
-	ErrRecordNotFound = errors.New("record not found")
+	"go.opentelemetry.io/otel"
+	"interview_assignment.mohamednaas.net/internal/password"
 )
 
-// A model struct to wrap around all the other models
+// The tracer used for the spans created by the models, e.g. around password hashing.
+// SQL statements are traced by the instrumented driver set up in main.
+var tracer = otel.Tracer("interview_assignment.mohamednaas.net/internal/data")
+
+// The repositories return the errors defined in errors.go, so that callers can check
+// for ErrRecordNotFound, ErrConflict etc. whichever store is in use.
+
+// UserRepository is implemented by the stores which hold users and admin rights.
+type UserRepository interface {
+	UserCreate(ctx context.Context, u User) (int, error)
+	UserGet(ctx context.Context, email string) (User, error)
+	UserGetID(ctx context.Context, ID int64) (User, error)
+	UsersGet(ctx context.Context) ([]*User, error)
+	UserUpdatePicture(ctx context.Context, picture, email string) error
+	UserUpdate(ctx context.Context, u User, email string) error
+	UserUpdatePassword(ctx context.Context, email, password string) error
+	UserDelete(ctx context.Context, email string) error
+	CheckPasswordMatches(ctx context.Context, u User, pass string) (bool, error)
+	UserRehashPassword(ctx context.Context, u User, pass string) (bool, error)
+	IsAdmin(ctx context.Context, id int) (bool, error)
+	AdminGrant(ctx context.Context, id int) error
+	AdminRevoke(ctx context.Context, id int) error
+}
+
+// CategoryRepository is implemented by the stores which hold categories.
+type CategoryRepository interface {
+	CategoryCreate(ctx context.Context, c Category) (int, error)
+	CategoriesGet(ctx context.Context) ([]*Category, error)
+	CategoryGet(ctx context.Context, id int) (Category, error)
+	CategoryUpdate(ctx context.Context, c *Category) error
+	CategoryDelete(ctx context.Context, id int) error
+}
+
+// UserCategoriesRepository is implemented by the stores which hold the categories
+// assigned to each user.
+type UserCategoriesRepository interface {
+	InsertUserCategories(ctx context.Context, userID, categoryID, grantedBy int) error
+	DeleteUserCategories(ctx context.Context, userID, categoryID int) error
+	UserCategoriesGet(ctx context.Context, userId int) ([]*Category, error)
+	UserCategoryGrantsGet(ctx context.Context, userID int, filters Filters) ([]*CategoryGrant, Metadata, error)
+	CategoryUsersGet(ctx context.Context, categoryID int, filters Filters) ([]*UserGrant, Metadata, error)
+}
+
+// LoginFailureRepository is implemented by the stores which count failed logins.
+type LoginFailureRepository interface {
+	LoginFailureGet(ctx context.Context, key string) (LoginFailure, error)
+	LoginFailureRecord(ctx context.Context, key string, at, since time.Time) (LoginFailure, error)
+	LoginFailureRelease(ctx context.Context, key string) error
+	LoginFailureDelete(ctx context.Context, key string) error
+}
+
+// TOTPRepository is implemented by the stores which hold two-factor authentication
+// secrets and recovery codes.
+type TOTPRepository interface {
+	TOTPGet(ctx context.Context, userID int) (TOTP, error)
+	TOTPSet(ctx context.Context, userID int, secret string) error
+	TOTPConfirm(ctx context.Context, userID int) error
+	TOTPUseCounter(ctx context.Context, userID int, counter int64) (bool, error)
+	TOTPDelete(ctx context.Context, userID int) error
+	RecoveryCodesReplace(ctx context.Context, userID int, hashes [][]byte) error
+	RecoveryCodeUse(ctx context.Context, userID int, hash []byte) (bool, error)
+	RecoveryCodesCount(ctx context.Context, userID int) (int, error)
+}
+
+// A model struct to wrap around all the other models. The fields are interfaces so
+// that the handlers can run against either the PostgreSQL models returned by
+// NewModels() or the in-memory ones returned by NewMemoryModels().
 type Models struct {
-	Users          UserModel
-	Categories     CategoryModel
-	UserCategories UserCategoriesModel
+	Users          UserRepository
+	Categories     CategoryRepository
+	UserCategories UserCategoriesRepository
+	LoginFailures  LoginFailureRepository
+	TOTP           TOTPRepository
+
+	// tx starts the transactions for Transaction(), see tx.go.
+	tx transactor
+}
+
+// For ease of use, we also add a New() method which returns a Models struct. Every
+// operation is limited by the given timeouts, and passwords are hashed with hasher.
+func NewModels(db *sql.DB, timeouts Timeouts, hasher password.Hasher) Models {
+	return newModels(db, timeouts, hasher, &sqlTransactor{db: db, timeouts: timeouts, hasher: hasher})
 }
 
-// For ease of use, we also add a New() method which returns a Models struct
-func NewModels(db *sql.DB) Models {
+// newModels returns the SQL models running their queries on db, which is either the
+// connection pool or a transaction.
+func newModels(db DBTX, timeouts Timeouts, hasher password.Hasher, tx transactor) Models {
 	return Models{
-		Users:          UserModel{DB: db},
-		Categories:     CategoryModel{DB: db},
-		UserCategories: UserCategoriesModel{DB: db},
+		Users:          &UserModel{DB: db, Timeouts: timeouts, Hasher: hasher},
+		Categories:     &CategoryModel{DB: db, Timeouts: timeouts},
+		UserCategories: &UserCategoriesModel{DB: db, Timeouts: timeouts},
+		LoginFailures:  &LoginFailureModel{DB: db, Timeouts: timeouts},
+		TOTP:           &TOTPModel{DB: db, Timeouts: timeouts},
+		tx:             tx,
 	}
 }
@@ -0,0 +1,424 @@
+package data_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/migrate"
+	"interview_assignment.mohamednaas.net/internal/password"
+	"interview_assignment.mohamednaas.net/migrations"
+	_ "modernc.org/sqlite"
+)
+
+// newSQLiteDB returns a migrated SQLite database in a temporary directory.
+func newSQLiteDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	source, err := data.ParseDSN("sqlite:" + filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open(source.Driver, source.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files, err := migrations.For(string(source.Dialect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := migrate.New(db, string(source.Dialect), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newSQLiteModels returns models backed by a migrated SQLite database.
+func newSQLiteModels(t *testing.T) data.Models {
+	return data.NewModels(newSQLiteDB(t), data.DefaultTimeouts, password.DefaultHasher())
+}
+
+// testStores are the stores which the tests run against.
+var testStores = []struct {
+	name   string
+	models func(t *testing.T) data.Models
+}{
+	{"sqlite", newSQLiteModels},
+	{"memory", func(*testing.T) data.Models { return data.NewMemoryModels(password.DefaultHasher()) }},
+}
+
+// TestRepositoryContracts checks that the SQL and in-memory models return the same
+// errors, since the handler tests rely on the in-memory ones behaving like the database.
+func TestRepositoryContracts(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			models := store.models(t)
+			ctx := context.Background()
+
+			user := data.User{Name: "Alice", Email: "alice@example.com", Password: "pa55word1234"}
+			id, err := models.Users.UserCreate(ctx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := models.Users.UserCreate(ctx, user); !errors.Is(err, data.ErrDuplicateEmail) {
+				t.Errorf("creating a duplicate user returned %v; want ErrDuplicateEmail", err)
+			}
+
+			bob := data.User{Name: "Bob", Email: "bob@example.com", Password: "pa55word1234"}
+			if _, err := models.Users.UserCreate(ctx, bob); err != nil {
+				t.Fatal(err)
+			}
+			// Updates must be based on the current version, which starts at 1.
+			bob.Email = user.Email
+			if err := models.Users.UserUpdate(ctx, bob, "bob@example.com"); !errors.Is(err, data.ErrEditConflict) {
+				t.Errorf("updating a stale version returned %v; want ErrEditConflict", err)
+			}
+			bob.Version = 1
+			if err := models.Users.UserUpdate(ctx, bob, "bob@example.com"); !errors.Is(err, data.ErrDuplicateEmail) {
+				t.Errorf("updating to a taken email returned %v; want ErrDuplicateEmail", err)
+			}
+			bob.Email = "robert@example.com"
+			if err := models.Users.UserUpdate(ctx, bob, "bob@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := models.Users.UserGet(ctx, bob.Email); err != nil || got.Version != 2 {
+				t.Errorf("got user %+v, %v after an update; want version 2", got, err)
+			}
+			// An empty password leaves the password as it was.
+			bob.Email = "bob@example.com"
+			bob.Password = ""
+			bob.Version = 2
+			if err := models.Users.UserUpdate(ctx, bob, "robert@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if match, err := models.Users.CheckPasswordMatches(ctx, bob, "pa55word1234"); err != nil || !match {
+				t.Errorf("checking the unchanged password returned %t, %v; want a match", match, err)
+			}
+			if err := models.Users.UserUpdate(ctx, user, "nobody@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("updating a missing user returned %v; want ErrRecordNotFound", err)
+			}
+
+			got, err := models.Users.UserGet(ctx, user.Email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != id || got.Picture != "defaultpfp.jpeg" {
+				t.Errorf("got user %+v; want id %d with the default picture", got, id)
+			}
+			if _, err := models.Users.UserGet(ctx, "nobody@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("getting a missing user returned %v; want ErrRecordNotFound", err)
+			}
+			if match, err := models.Users.CheckPasswordMatches(ctx, user, user.Password); err != nil || !match {
+				t.Errorf("checking the password returned %t, %v; want a match", match, err)
+			}
+
+			if err := models.Users.AdminGrant(ctx, id); err != nil {
+				t.Fatal(err)
+			}
+			// Granting twice isn't an error, and still leaves a single admin row.
+			if err := models.Users.AdminGrant(ctx, id); err != nil {
+				t.Errorf("granting admin rights twice returned %v", err)
+			}
+			if isAdmin, err := models.Users.IsAdmin(ctx, id); err != nil || !isAdmin {
+				t.Errorf("checking admin rights after AdminGrant returned %t, %v; want true", isAdmin, err)
+			}
+
+			// The memory store starts empty, while the migrations add sample categories,
+			// so use names which aren't in either.
+			category := data.Category{Name: "test category"}
+			category.ID, err = models.Categories.CategoryCreate(ctx, category)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := models.Categories.CategoryCreate(ctx, category); !errors.Is(err, data.ErrDuplicateCategoryName) {
+				t.Errorf("creating a duplicate category returned %v; want ErrDuplicateCategoryName", err)
+			}
+			other := data.Category{Name: "other category"}
+			other.ID, err = models.Categories.CategoryCreate(ctx, other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other.Name = category.Name
+			other.Version = 1
+			if err := models.Categories.CategoryUpdate(ctx, &other); !errors.Is(err, data.ErrDuplicateCategoryName) {
+				t.Errorf("renaming to a taken name returned %v; want ErrDuplicateCategoryName", err)
+			}
+			other.Name = "renamed category"
+			if err := models.Categories.CategoryUpdate(ctx, &other); err != nil || other.Version != 2 {
+				t.Errorf("renaming returned %v with version %d; want version 2", err, other.Version)
+			}
+			stale := data.Category{ID: other.ID, Name: "stale", Version: 1}
+			if err := models.Categories.CategoryUpdate(ctx, &stale); !errors.Is(err, data.ErrEditConflict) {
+				t.Errorf("updating a stale version returned %v; want ErrEditConflict", err)
+			}
+			missing := data.Category{ID: 9999, Name: "missing", Version: 1}
+			if err := models.Categories.CategoryUpdate(ctx, &missing); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("updating a missing category returned %v; want ErrRecordNotFound", err)
+			}
+			if _, err := models.Categories.CategoryGet(ctx, 9999); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("getting a missing category returned %v; want ErrRecordNotFound", err)
+			}
+
+			if err := models.UserCategories.InsertUserCategories(ctx, id, category.ID, id); err != nil {
+				t.Fatal(err)
+			}
+			if err := models.UserCategories.InsertUserCategories(ctx, id, category.ID, id); !errors.Is(err, data.ErrCategoryAlreadyAssigned) {
+				t.Errorf("assigning a category twice returned %v; want ErrCategoryAlreadyAssigned", err)
+			}
+			if err := models.UserCategories.InsertUserCategories(ctx, 9999, other.ID, 0); !errors.Is(err, data.ErrForeignKeyViolation) {
+				t.Errorf("assigning a category to a missing user returned %v; want ErrForeignKeyViolation", err)
+			}
+			if err := models.UserCategories.DeleteUserCategories(ctx, id, other.ID); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("removing an unassigned category returned %v; want ErrRecordNotFound", err)
+			}
+			if err := models.Categories.CategoryDelete(ctx, category.ID); !errors.Is(err, data.ErrRecordInUse) {
+				t.Errorf("deleting an assigned category returned %v; want ErrRecordInUse", err)
+			}
+			if err := models.Categories.CategoryDelete(ctx, 9999); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("deleting a missing category returned %v; want ErrRecordNotFound", err)
+			}
+			if err := models.Users.UserDelete(ctx, user.Email); !errors.Is(err, data.ErrCannotDeleteAdmin) {
+				t.Errorf("deleting an admin returned %v; want ErrCannotDeleteAdmin", err)
+			}
+			if err := models.Users.AdminGrant(ctx, 9999); !errors.Is(err, data.ErrForeignKeyViolation) {
+				t.Errorf("granting admin rights to a missing user returned %v; want ErrForeignKeyViolation", err)
+			}
+			if err := models.Users.UserUpdatePicture(ctx, "x.png", "nobody@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("updating a missing user's picture returned %v; want ErrRecordNotFound", err)
+			}
+
+			// Bob has no categories and isn't an admin, so he can be deleted once.
+			if err := models.Users.UserDelete(ctx, "bob@example.com"); err != nil {
+				t.Errorf("deleting a user returned %v", err)
+			}
+			if err := models.Users.UserDelete(ctx, "bob@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("deleting a missing user returned %v; want ErrRecordNotFound", err)
+			}
+			categories, err := models.UserCategories.UserCategoriesGet(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(categories) != 1 || categories[0].ID != category.ID {
+				t.Errorf("got user categories %v; want only %d", categories, category.ID)
+			}
+
+			// The grants are listed a page at a time, with who assigned each category.
+			if err := models.UserCategories.InsertUserCategories(ctx, id, other.ID, 0); err != nil {
+				t.Fatal(err)
+			}
+			grants, metadata, err := models.UserCategories.UserCategoryGrantsGet(ctx, id, data.Filters{Page: 2, PageSize: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(grants) != 1 || grants[0].ID != other.ID || grants[0].GrantedBy != nil || grants[0].GrantedAt.IsZero() {
+				t.Errorf("got grants %+v on page 2; want only %d, granted by nobody", grants, other.ID)
+			}
+			if metadata != (data.Metadata{CurrentPage: 2, PageSize: 1, FirstPage: 1, LastPage: 2, TotalRecords: 2}) {
+				t.Errorf("got metadata %+v; want page 2 of 2", metadata)
+			}
+			users, _, err := models.UserCategories.CategoryUsersGet(ctx, category.ID, data.Filters{Page: 1, PageSize: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 1 || users[0].ID != id || users[0].GrantedBy == nil || *users[0].GrantedBy != id {
+				t.Errorf("got category users %+v; want only %d, granted by themselves", users, id)
+			}
+			if since := time.Since(users[0].GrantedAt); since < -time.Minute || since > time.Minute {
+				t.Errorf("got granted_at %v; want about now", users[0].GrantedAt)
+			}
+		})
+	}
+}
+
+// TestUserRehashPassword checks that a hash made with outdated settings is replaced on
+// the next successful login, using two sets of models on the same database to stand in
+// for a configuration change.
+func TestUserRehashPassword(t *testing.T) {
+	db := newSQLiteDB(t)
+	ctx := context.Background()
+
+	old := password.DefaultHasher()
+	old.Algorithm = password.Bcrypt
+	old.BcryptCost = 4
+	user := data.User{Name: "Alice", Email: "alice@example.com", Password: "pa55word1234"}
+	if _, err := data.NewModels(db, data.DefaultTimeouts, old).Users.UserCreate(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	models := data.NewModels(db, data.DefaultTimeouts, password.DefaultHasher())
+	for i, want := range []bool{true, false} {
+		rehashed, err := models.Users.UserRehashPassword(ctx, user, user.Password)
+		if err != nil || rehashed != want {
+			t.Errorf("rehash %d returned %t, %v; want %t", i+1, rehashed, err, want)
+		}
+		if match, err := models.Users.CheckPasswordMatches(ctx, user, user.Password); err != nil || !match {
+			t.Errorf("checking the password after rehash %d returned %t, %v; want a match", i+1, match, err)
+		}
+	}
+
+	var hash string
+	if err := db.QueryRow(`SELECT password_hash FROM users WHERE email = $1`, user.Email).Scan(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Errorf("got hash %q; want an argon2id hash", hash)
+	}
+	// The rehash isn't a change clients can see.
+	if got, err := models.Users.UserGet(ctx, user.Email); err != nil || got.Version != 1 {
+		t.Errorf("got user %+v, %v; want version 1", got, err)
+	}
+}
+
+func TestIsAdminError(t *testing.T) {
+	db := newSQLiteDB(t)
+	models := data.NewModels(db, data.DefaultTimeouts, password.DefaultHasher())
+	ctx := context.Background()
+
+	if isAdmin, err := models.Users.IsAdmin(ctx, 1); err != nil || isAdmin {
+		t.Errorf("checking a user who isn't an admin returned %t, %v; want false, nil", isAdmin, err)
+	}
+	// A failed query mustn't be mistaken for the user not being an admin.
+	db.Close()
+	if _, err := models.Users.IsAdmin(ctx, 1); err == nil {
+		t.Error("checking admin rights on a closed database returned no error")
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			models := store.models(t)
+			ctx := context.Background()
+			key := data.AccountLoginKey("Alice@example.com")
+			start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+			if got, err := models.LoginFailures.LoginFailureGet(ctx, key); err != nil || got.Failures != 0 {
+				t.Errorf("got %+v, %v before any failures; want no failures", got, err)
+			}
+
+			// Failures within the window add up; one after a gap starts again.
+			steps := []struct {
+				at   time.Duration
+				want int
+			}{{0, 1}, {time.Minute, 2}, {2 * time.Minute, 3}, {time.Hour, 1}, {time.Hour + time.Second, 2}}
+			for _, step := range steps {
+				at := start.Add(step.at)
+				got, err := models.LoginFailures.LoginFailureRecord(ctx, key, at, at.Add(-15*time.Minute))
+				if err != nil || got.Failures != step.want {
+					t.Fatalf("recording a failure at +%s returned %+v, %v; want %d failures", step.at, got, err, step.want)
+				}
+			}
+
+			got, err := models.LoginFailures.LoginFailureGet(ctx, data.AccountLoginKey("alice@example.com"))
+			if err != nil || got.Failures != 2 || !got.LastFailure.Equal(start.Add(time.Hour+time.Second)) {
+				t.Errorf("got %+v, %v; want 2 failures, the last at %v", got, err, start.Add(time.Hour+time.Second))
+			}
+
+			// Releasing takes a failure back without touching the time, and stops at 0.
+			for i := 0; i < 3; i++ {
+				if err := models.LoginFailures.LoginFailureRelease(ctx, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err = models.LoginFailures.LoginFailureGet(ctx, key)
+			if err != nil || got.Failures != 0 || !got.LastFailure.Equal(start.Add(time.Hour+time.Second)) {
+				t.Errorf("got %+v, %v after releasing; want no failures, the last at %v", got, err, start.Add(time.Hour+time.Second))
+			}
+
+			if err := models.LoginFailures.LoginFailureDelete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			if err := models.LoginFailures.LoginFailureDelete(ctx, key); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("deleting again returned %v; want ErrRecordNotFound", err)
+			}
+		})
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			models := store.models(t)
+			ctx := context.Background()
+
+			id, err := models.Users.UserCreate(ctx, data.User{Name: "Alice", Email: "alice@example.com", Password: "pa55word1234"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := models.TOTP.TOTPGet(ctx, id); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("got %v before enrolling; want ErrRecordNotFound", err)
+			}
+			if err := models.TOTP.TOTPSet(ctx, 999, "SECRET"); !errors.Is(err, data.ErrForeignKeyViolation) {
+				t.Errorf("enrolling a missing user returned %v; want ErrForeignKeyViolation", err)
+			}
+
+			// An unconfirmed secret can be replaced, a confirmed one can't.
+			for _, secret := range []string{"FIRST", "SECOND"} {
+				if err := models.TOTP.TOTPSet(ctx, id, secret); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := models.TOTP.TOTPConfirm(ctx, id); err != nil {
+				t.Fatal(err)
+			}
+			if err := models.TOTP.TOTPSet(ctx, id, "THIRD"); !errors.Is(err, data.ErrConflict) {
+				t.Errorf("replacing a confirmed secret returned %v; want ErrConflict", err)
+			}
+			got, err := models.TOTP.TOTPGet(ctx, id)
+			if err != nil || got.Secret != "SECOND" || !got.Confirmed {
+				t.Errorf("got %+v, %v; want the confirmed second secret", got, err)
+			}
+
+			// Each counter can only be used once, and only going forwards.
+			for _, step := range []struct {
+				counter int64
+				want    bool
+			}{{100, true}, {100, false}, {99, false}, {101, true}} {
+				if ok, err := models.TOTP.TOTPUseCounter(ctx, id, step.counter); err != nil || ok != step.want {
+					t.Errorf("using counter %d returned %v, %v; want %v", step.counter, ok, err, step.want)
+				}
+			}
+
+			// Recovery codes can each be used once.
+			err = models.Transaction(ctx, func(tx data.Models) error {
+				return tx.TOTP.RecoveryCodesReplace(ctx, id, [][]byte{[]byte("code-1"), []byte("code-2")})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range []struct {
+				code string
+				want bool
+			}{{"code-1", true}, {"code-1", false}, {"code-3", false}} {
+				if ok, err := models.TOTP.RecoveryCodeUse(ctx, id, []byte(step.code)); err != nil || ok != step.want {
+					t.Errorf("using %s returned %v, %v; want %v", step.code, ok, err, step.want)
+				}
+			}
+			if n, err := models.TOTP.RecoveryCodesCount(ctx, id); err != nil || n != 1 {
+				t.Errorf("got %d, %v codes left; want 1", n, err)
+			}
+
+			// Deleting the secret takes the recovery codes with it.
+			if err := models.TOTP.TOTPDelete(ctx, id); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := models.TOTP.RecoveryCodesCount(ctx, id); err != nil || n != 0 {
+				t.Errorf("got %d, %v codes after deleting; want 0", n, err)
+			}
+			if err := models.TOTP.TOTPDelete(ctx, id); !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("deleting again returned %v; want ErrRecordNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Operations lists the model operations which can be given their own timeout in
+// Timeouts.Operations. The names are those of the repository methods.
+var Operations = []string{
+	"UserCreate", "UserGet", "UserGetID", "UsersGet", "UserUpdatePicture", "UserUpdate",
+	"UserUpdatePassword", "UserDelete", "CheckPasswordMatches", "UserRehashPassword",
+	"IsAdmin", "AdminGrant", "AdminRevoke",
+	"CategoryCreate", "CategoriesGet", "CategoryGet", "CategoryUpdate", "CategoryDelete",
+	"InsertUserCategories", "DeleteUserCategories", "UserCategoriesGet", "UserCategoryGrantsGet",
+	"CategoryUsersGet",
+	"LoginFailureGet", "LoginFailureRecord", "LoginFailureRelease", "LoginFailureDelete",
+	"TOTPGet", "TOTPSet", "TOTPConfirm", "TOTPUseCounter", "TOTPDelete",
+	"RecoveryCodesReplace", "RecoveryCodeUse", "RecoveryCodesCount",
+}
+
+// Timeouts limits how long each model operation may take, including any password
+// hashing it does before querying the database. The limit applies on top of the context
+// passed in by the caller, so a query is also cancelled when the client disconnects or
+// the server gives up on the request.
+type Timeouts struct {
+	// Default applies to every operation without an entry in Operations. Zero means no
+	// limit other than the caller's context.
+	Default time.Duration
+	// Operations overrides the default for individual operations, keyed by the names in
+	// the Operations list, e.g. "UsersGet".
+	Operations map[string]time.Duration
+}
+
+// DefaultTimeouts gives every operation 3 seconds.
+var DefaultTimeouts = Timeouts{Default: 3 * time.Second}
+
+// IsOperation reports whether name is one of the Operations.
+func IsOperation(name string) bool {
+	for _, op := range Operations {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
+
+// context returns a context derived from ctx which expires after the timeout for the
+// named operation.
+func (t Timeouts) context(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	timeout, ok := t.Operations[operation]
+	if !ok {
+		timeout = t.Default
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
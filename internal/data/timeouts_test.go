@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsContext(t *testing.T) {
+	timeouts := Timeouts{
+		Default:    time.Minute,
+		Operations: map[string]time.Duration{"UsersGet": time.Hour},
+	}
+
+	tests := []struct {
+		name      string
+		timeouts  Timeouts
+		operation string
+		want      time.Duration
+	}{
+		{"default", timeouts, "UserGet", time.Minute},
+		{"override", timeouts, "UsersGet", time.Hour},
+		{"no limit", Timeouts{}, "UserGet", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.timeouts.context(context.Background(), tt.operation)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tt.want == 0 {
+				if ok {
+					t.Fatalf("got deadline %v; want none", deadline)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("got no deadline")
+			}
+			if remaining := time.Until(deadline); remaining > tt.want || remaining < tt.want-time.Second {
+				t.Errorf("got deadline in %v; want %v", remaining, tt.want)
+			}
+		})
+	}
+
+	t.Run("parent cancelled", func(t *testing.T) {
+		parent, cancelParent := context.WithCancel(context.Background())
+		ctx, cancel := timeouts.context(parent, "UserGet")
+		defer cancel()
+
+		cancelParent()
+		if ctx.Err() != context.Canceled {
+			t.Errorf("got %v; want context.Canceled", ctx.Err())
+		}
+	})
+}
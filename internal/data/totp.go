@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type TOTPModel struct {
+	DB       DBTX
+	Timeouts Timeouts
+}
+
+// TOTP is a user's two-factor authentication secret. A secret is unconfirmed from
+// enrolment until the user proves their authenticator has it by sending a code, and
+// only a confirmed one is asked for at login. LastCounter is the time step of the last
+// code accepted, so that the same code can't be used again.
+type TOTP struct {
+	UserID      int
+	Secret      string
+	Confirmed   bool
+	LastCounter int64
+}
+
+// Getting a user's TOTP secret. Returns ErrRecordNotFound if they haven't enrolled.
+func (m *TOTPModel) TOTPGet(ctx context.Context, userID int) (TOTP, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "TOTPGet")
+	defer cancel()
+
+	t := TOTP{UserID: userID}
+	q := `SELECT secret, confirmed, last_counter FROM user_totp WHERE user_id = $1`
+	err := m.DB.QueryRowContext(ctx, q, userID).Scan(&t.Secret, &t.Confirmed, &t.LastCounter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return t, ErrRecordNotFound
+		}
+		return t, translateError(err)
+	}
+	return t, nil
+}
+
+// Setting a new unconfirmed secret for a user, replacing an earlier unconfirmed one.
+// Returns ErrConflict if the user already has a confirmed secret, which has to be
+// deleted first.
+func (m *TOTPModel) TOTPSet(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "TOTPSet")
+	defer cancel()
+
+	q := `INSERT INTO user_totp (user_id, secret, confirmed, last_counter) VALUES ($1, $2, false, 0)
+	ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_counter = 0
+	WHERE user_totp.confirmed = false`
+	result, err := m.DB.ExecContext(ctx, q, userID, secret)
+	if err != nil {
+		return translateError(err)
+	}
+	if err := expectRows(result); errors.Is(err, ErrRecordNotFound) {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Confirming a user's secret, after which it is asked for at login.
+func (m *TOTPModel) TOTPConfirm(ctx context.Context, userID int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "TOTPConfirm")
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `UPDATE user_totp SET confirmed = true WHERE user_id = $1`, userID)
+	if err != nil {
+		return translateError(err)
+	}
+	return expectRows(result)
+}
+
+// Recording that the code for counter was used. Returns false if a code for the same or
+// a later counter was already used, in which case the code must be refused. The check
+// is part of the update, so two requests with the same code can't both succeed.
+func (m *TOTPModel) TOTPUseCounter(ctx context.Context, userID int, counter int64) (bool, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "TOTPUseCounter")
+	defer cancel()
+
+	q := `UPDATE user_totp SET last_counter = $2 WHERE user_id = $1 AND last_counter < $2`
+	result, err := m.DB.ExecContext(ctx, q, userID, counter)
+	if err != nil {
+		return false, translateError(err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Deleting a user's secret along with their recovery codes, which turns two-factor
+// authentication off for them.
+func (m *TOTPModel) TOTPDelete(ctx context.Context, userID int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "TOTPDelete")
+	defer cancel()
+
+	// The recovery codes are removed by their foreign key's ON DELETE CASCADE.
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return translateError(err)
+	}
+	return expectRows(result)
+}
+
+// Replacing a user's recovery codes with new ones, given as the hashes made by
+// totp.HashRecoveryCode(). Returns ErrForeignKeyViolation if the user hasn't enrolled.
+// Run it in a Transaction() so that a failure part way doesn't leave some codes behind.
+func (m *TOTPModel) RecoveryCodesReplace(ctx context.Context, userID int, hashes [][]byte) error {
+	ctx, cancel := m.Timeouts.context(ctx, "RecoveryCodesReplace")
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return translateError(err)
+	}
+	for _, hash := range hashes {
+		_, err := m.DB.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+// Using up one of a user's recovery codes, given by its hash. Returns false if the user
+// has no such code, either because it's wrong or because it was already used.
+func (m *TOTPModel) RecoveryCodeUse(ctx context.Context, userID int, hash []byte) (bool, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "RecoveryCodeUse")
+	defer cancel()
+
+	q := `DELETE FROM user_recovery_codes WHERE user_id = $1 AND code_hash = $2`
+	result, err := m.DB.ExecContext(ctx, q, userID, hash)
+	if err != nil {
+		return false, translateError(err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Counting a user's unused recovery codes.
+func (m *TOTPModel) RecoveryCodesCount(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "RecoveryCodesCount")
+	defer cancel()
+
+	var count int
+	q := `SELECT count(*) FROM user_recovery_codes WHERE user_id = $1`
+	if err := m.DB.QueryRowContext(ctx, q, userID).Scan(&count); err != nil {
+		return 0, translateError(err)
+	}
+	return count, nil
+}
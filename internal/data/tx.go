@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"interview_assignment.mohamednaas.net/internal/password"
+)
+
+// DBTX is the part of *sql.DB and *sql.Tx used by the models, so that the same model
+// code runs either directly against the pool or inside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// transactor is implemented by each store to run Models.Transaction().
+type transactor interface {
+	transaction(ctx context.Context, fn func(Models) error) error
+}
+
+// maxTxAttempts is the number of times a transaction is tried before the error which
+// made it fail is returned.
+const maxTxAttempts = 3
+
+// errTxConflict is returned by the in-memory store when another write happened while a
+// transaction was running, the equivalent of a serialization failure.
+var errTxConflict = errors.New("transaction conflicts with a concurrent update")
+
+// Transaction runs fn with a copy of the models whose operations all take place in one
+// transaction, which is committed if fn returns nil and rolled back otherwise. fn's
+// error is returned unchanged so that callers can check it as usual.
+//
+// If the transaction fails because of a serialization failure or a deadlock, it is
+// rolled back and fn is called again, up to 3 times in all. fn should therefore only
+// make changes through the models it is given. Calling Transaction() on those models
+// again joins the transaction already running.
+func (m Models) Transaction(ctx context.Context, fn func(tx Models) error) error {
+	if m.tx == nil {
+		// These models already belong to a transaction (or were assembled by hand and
+		// have no store to start one in), so fn runs with them as they are.
+		return fn(m)
+	}
+	return m.tx.transaction(ctx, fn)
+}
+
+// retryTx calls attempt until it succeeds, fails with an error which isn't worth
+// retrying, or has been tried maxTxAttempts times. It waits a little longer before each
+// retry, with some jitter so that transactions which clashed don't clash again.
+func retryTx(ctx context.Context, attempt func() error) error {
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i == maxTxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		backoff := time.Duration(i) * 10 * time.Millisecond
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// sqlTransactor starts transactions on a database.
+type sqlTransactor struct {
+	db       *sql.DB
+	timeouts Timeouts
+	hasher   password.Hasher
+}
+
+func (t *sqlTransactor) transaction(ctx context.Context, fn func(Models) error) error {
+	return retryTx(ctx, func() error {
+		// PostgreSQL only detects every anomaly between concurrent transactions at the
+		// serializable level. SQLite transactions are always serializable, and the
+		// driver ignores the option.
+		tx, err := t.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		if err != nil {
+			return err
+		}
+		// Rollback() is a no-op once the transaction has been committed, and makes sure
+		// it isn't left open if fn panics.
+		defer tx.Rollback()
+
+		if err := fn(newModels(tx, t.timeouts, t.hasher, nil)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
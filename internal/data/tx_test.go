@@ -0,0 +1,114 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"interview_assignment.mohamednaas.net/internal/data"
+	"interview_assignment.mohamednaas.net/internal/password"
+)
+
+func TestTransaction(t *testing.T) {
+	for _, store := range testStores {
+		t.Run(store.name, func(t *testing.T) {
+			models := store.models(t)
+			ctx := context.Background()
+
+			// A transaction which fails is rolled back, and its error returned as is.
+			errFailed := errors.New("failed")
+			err := models.Transaction(ctx, func(tx data.Models) error {
+				if _, err := tx.Categories.CategoryCreate(ctx, data.Category{Name: "rolled back"}); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			if err != errFailed {
+				t.Fatalf("got %v; want the error from fn", err)
+			}
+			if hasCategory(t, models, "rolled back") {
+				t.Error("category created in a failed transaction exists")
+			}
+
+			// A nested transaction joins the outer one, so it is rolled back with it.
+			err = models.Transaction(ctx, func(tx data.Models) error {
+				err := tx.Transaction(ctx, func(tx data.Models) error {
+					_, err := tx.Categories.CategoryCreate(ctx, data.Category{Name: "nested"})
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				return errFailed
+			})
+			if err != errFailed {
+				t.Fatalf("got %v; want the error from fn", err)
+			}
+			if hasCategory(t, models, "nested") {
+				t.Error("category created in a nested transaction outlived the outer one")
+			}
+
+			// A transaction which succeeds is committed.
+			err = models.Transaction(ctx, func(tx data.Models) error {
+				id, err := tx.Categories.CategoryCreate(ctx, data.Category{Name: "committed"})
+				if err != nil {
+					return err
+				}
+				// The transaction sees its own changes.
+				_, err = tx.Categories.CategoryGet(ctx, id)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hasCategory(t, models, "committed") {
+				t.Error("category created in a committed transaction doesn't exist")
+			}
+		})
+	}
+}
+
+// TestTransactionRetry checks that a transaction which clashes with a concurrent write
+// is run again, using the in-memory store where the clash is easy to set up.
+func TestTransactionRetry(t *testing.T) {
+	models := data.NewMemoryModels(password.DefaultHasher())
+	ctx := context.Background()
+
+	attempts := 0
+	err := models.Transaction(ctx, func(tx data.Models) error {
+		attempts++
+		if attempts == 1 {
+			// Write outside the transaction while it is running.
+			if _, err := models.Categories.CategoryCreate(ctx, data.Category{Name: "concurrent"}); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Categories.CategoryCreate(ctx, data.Category{Name: "retried"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts; want 2", attempts)
+	}
+	if !hasCategory(t, models, "concurrent") || !hasCategory(t, models, "retried") {
+		t.Error("want both the concurrent and the retried categories")
+	}
+}
+
+// hasCategory reports whether a category with the given name exists.
+func hasCategory(t *testing.T, models data.Models, name string) bool {
+	t.Helper()
+
+	categories, err := models.Categories.CategoriesGet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range categories {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -2,39 +2,78 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 )
 
 type UserCategoriesModel struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
 }
 
-func (m *UserCategoriesModel) InsertUserCategories(userID, categoryID int) error {
-	// prep the query
-	q := `insert into user_categories (user_id, category_id) values ($1, $2) returning user_id`
+// Grant records when a category was assigned to a user, and by whom. GrantedBy is nil
+// when that isn't known: the category was assigned with the admin CLI or before grants
+// were recorded, or the admin who assigned it has been deleted since.
+type Grant struct {
+	GrantedAt time.Time `json:"granted_at"`
+	GrantedBy *int      `json:"granted_by"`
+}
+
+// CategoryGrant is a category assigned to a user, as listed for that user.
+type CategoryGrant struct {
+	Category
+	Grant
+}
+
+// UserGrant is a user a category is assigned to, as listed for that category.
+type UserGrant struct {
+	User
+	Grant
+}
+
+// Assigning a category to a user, recording grantedBy as the user who assigned it (0 if
+// unknown). A category can only be assigned to one user, so this returns
+// ErrCategoryAlreadyAssigned if it already is, and ErrForeignKeyViolation if the user or
+// category doesn't exist.
+func (m *UserCategoriesModel) InsertUserCategories(ctx context.Context, userID, categoryID, grantedBy int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "InsertUserCategories")
+	defer cancel()
+
+	// prep the query, granted_at defaults to the current time
+	q := `insert into user_categories (user_id, category_id, granted_by) values ($1, $2, $3) returning user_id`
+
+	var by any
+	if grantedBy != 0 {
+		by = grantedBy
+	}
 
-	err := m.DB.QueryRow(q, userID, categoryID).Scan(&userID)
-	return err
+	err := m.DB.QueryRowContext(ctx, q, userID, categoryID, by).Scan(&userID)
+	return translateError(err)
 }
 
-func (m *UserCategoriesModel) DeleteUserCategories(userID, categoryID int) {
+// Removing a category from a user, ErrRecordNotFound if it wasn't assigned to them.
+func (m *UserCategoriesModel) DeleteUserCategories(ctx context.Context, userID, categoryID int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "DeleteUserCategories")
+	defer cancel()
+
 	// prep the query
 	q := `DELETE FROM user_categories WHERE user_id = $1 AND category_id = $2`
 
-	m.DB.QueryRow(q, userID, categoryID)
-
+	result, err := m.DB.ExecContext(ctx, q, userID, categoryID)
+	if err != nil {
+		return translateError(err)
+	}
+	return expectRows(result)
 }
 
-func (m *UserCategoriesModel) UserCategoriesGet(userId int) ([]*Category, error) {
-	q := `SELECT id, name FROM categories 
+func (m *UserCategoriesModel) UserCategoriesGet(ctx context.Context, userId int) ([]*Category, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UserCategoriesGet")
+	defer cancel()
+
+	q := `SELECT id, name, version FROM categories 
 	JOIN user_categories ON categories.id = user_categories.category_id
 	WHERE user_categories.user_id = $1
 	ORDER BY user_categories.category_id`
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, q, userId)
 	if err != nil {
 		return nil, err
@@ -52,6 +91,7 @@ func (m *UserCategoriesModel) UserCategoriesGet(userId int) ([]*Category, error)
 		err := rows.Scan(
 			&c.ID,
 			&c.Name,
+			&c.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -68,3 +108,79 @@ func (m *UserCategoriesModel) UserCategoriesGet(userId int) ([]*Category, error)
 	// If everything went OK, then return the slice of categories.
 	return categories, nil
 }
+
+// Getting a page of the categories assigned to a user, with when and by whom each one
+// was assigned, ordered by category id
+func (m *UserCategoriesModel) UserCategoryGrantsGet(ctx context.Context, userID int, filters Filters) ([]*CategoryGrant, Metadata, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UserCategoryGrantsGet")
+	defer cancel()
+
+	// count(*) OVER() adds the total number of matching rows to every row, so the
+	// metadata doesn't need a second query
+	q := `SELECT count(*) OVER(), categories.id, categories.name, categories.version,
+		user_categories.granted_at, user_categories.granted_by
+	FROM categories
+	JOIN user_categories ON categories.id = user_categories.category_id
+	WHERE user_categories.user_id = $1
+	ORDER BY categories.id
+	LIMIT $2 OFFSET $3`
+
+	rows, err := m.DB.QueryContext(ctx, q, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	grants := []*CategoryGrant{}
+	for rows.Next() {
+		var g CategoryGrant
+		err := rows.Scan(&totalRecords, &g.ID, &g.Name, &g.Version, &g.GrantedAt, &g.GrantedBy)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		grants = append(grants, &g)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return grants, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Getting a page of the users a category is assigned to, with when and by whom it was
+// assigned, ordered by user id
+func (m *UserCategoriesModel) CategoryUsersGet(ctx context.Context, categoryID int, filters Filters) ([]*UserGrant, Metadata, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "CategoryUsersGet")
+	defer cancel()
+
+	q := `SELECT count(*) OVER(), users.id, users.name, users.email, users.pfp_filepath, users.version,
+		user_categories.granted_at, user_categories.granted_by
+	FROM users
+	JOIN user_categories ON users.id = user_categories.user_id
+	WHERE user_categories.category_id = $1
+	ORDER BY users.id
+	LIMIT $2 OFFSET $3`
+
+	rows, err := m.DB.QueryContext(ctx, q, categoryID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	grants := []*UserGrant{}
+	for rows.Next() {
+		var g UserGrant
+		err := rows.Scan(&totalRecords, &g.ID, &g.Name, &g.Email, &g.Picture, &g.Version, &g.GrantedAt, &g.GrantedBy)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		grants = append(grants, &g)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return grants, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
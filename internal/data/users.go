@@ -4,30 +4,30 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 
-	"net/http"
-	"time"
-
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"interview_assignment.mohamednaas.net/internal/password"
 	"interview_assignment.mohamednaas.net/internal/validator"
 )
 
-var (
-	ErrDuplicateEmail = errors.New("duplicate email")
-)
-
 // the user model used for connecting user info with the databse
 type UserModel struct {
-	DB *sql.DB
+	DB       DBTX
+	Timeouts Timeouts
+	// Hasher hashes new passwords, and decides which stored hashes are out of date.
+	Hasher password.Hasher
 }
 
 type User struct {
 	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"-"`
-	Picture  string `json:"picture"`
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"-" validate:"required"`
+	// Picture is the file name of the profile picture, which the API turns into a URL.
+	Picture string `json:"picture"`
+	// Version starts at 1 and is incremented every time the user is updated.
+	Version int `json:"version"`
 }
 
 // Declare a new AnonymousUser variable.
@@ -38,207 +38,326 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-// Perform checks to make sure that the registered user is valid
-func ValidateUserRegisteration(v *validator.Validator, u *User) {
-	v.Check(validator.NotBlank(u.Name), "name", "Name must be provided")
-	v.Check(validator.NotBlank(u.Email), "email", "Email must be provided")
-	v.Check(validator.NotBlank(u.Password), "password", "Password must be provided")
-	v.Check(validator.Matches(u.Email, validator.EmailRX), "email", "Email must be a valid address")
-	v.Check(validator.MinChars(u.Password, 8), "password", "Password must be atleast 8 characters long")
+// Perform checks to make sure that the registered user is valid, using the rules in the
+// User struct's validate tags and the password policy
+func ValidateUserRegisteration(v *validator.Validator, u *User, policy password.Policy) {
+	v.Struct(u)
+	ValidatePasswordPlaintext(v, policy, u.Password, u)
+}
 
+// ValidatePasswordPlaintext checks a new password for the user against the password
+// policy, which among other things rejects passwords containing their name or email.
+func ValidatePasswordPlaintext(v *validator.Validator, policy password.Policy, plaintext string, u *User) {
+	v.Var("password", plaintext, "required")
+	policy.Validate(v, "password", plaintext, u.Name, u.Email)
 }
 
 // Inserting a user into the database, returns newly created user's id
-func (m *UserModel) UserCreate(u User) (int, error) {
+func (m *UserModel) UserCreate(ctx context.Context, u User) (int, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UserCreate")
+	defer cancel()
+
 	// Define query used
 	q := `INSERT INTO users (name, email, password_hash) VALUES ($1, $2, $3) RETURNING id`
 
 	// Generate password hash to insert into db
-	pHashed, err := Set(u.Password)
+	pHashed, err := hashPassword(ctx, m.Hasher, u.Password)
 	if err != nil {
 		return 0, err
 	}
 
 	args := []any{u.Name, u.Email, pHashed}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
-	// constraint that we set up in the previous chapter. We check for this error
-	// specifically, and return custom ErrDuplicateEmail error instead.
+	// constraint, which translateError() turns into ErrDuplicateEmail.
 	err = m.DB.QueryRowContext(ctx, q, args...).Scan(&u.ID)
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return 0, ErrDuplicateEmail
-		default:
-			return 0, err
-		}
+		return 0, translateError(err)
 	}
 
 	return u.ID, nil
 }
 
 // Getting user info from the database
-func (m *UserModel) UserGet(email string, r http.Request) (User, error) {
+func (m *UserModel) UserGet(ctx context.Context, email string) (User, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UserGet")
+	defer cancel()
+
 	user := User{}
 	// prepare query
-	q := `SELECT id, name, email, pfp_filepath FROM users WHERE email = $1`
+	q := `SELECT id, name, email, pfp_filepath, version FROM users WHERE email = $1`
 
 	// excecute query
-	err := m.DB.QueryRow(q, email).Scan(&user.ID, &user.Name, &user.Email, &user.Picture)
-
+	err := m.DB.QueryRowContext(ctx, q, email).Scan(&user.ID, &user.Name, &user.Email, &user.Picture, &user.Version)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return user, ErrRecordNotFound
-		}
-		return user, err
+		return user, translateError(err)
 	}
 
-	// Make nice URl to find image in
-	user.Picture = fmt.Sprintf("http://%s/static/%s", r.Host, user.Picture)
-
 	// all good? retirn user data
 	return user, nil
 }
 
 // Getting user info from the database
-func (m *UserModel) UserGetID(ID int64, r http.Request) (User, error) {
+func (m *UserModel) UserGetID(ctx context.Context, ID int64) (User, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UserGetID")
+	defer cancel()
+
 	user := User{}
 	// prepare query
-	q := `SELECT id, name, email, pfp_filepath FROM users WHERE id = $1`
+	q := `SELECT id, name, email, pfp_filepath, version FROM users WHERE id = $1`
 
 	// excecute query
-	err := m.DB.QueryRow(q, ID).Scan(&user.ID, &user.Name, &user.Email, &user.Picture)
-
+	err := m.DB.QueryRowContext(ctx, q, ID).Scan(&user.ID, &user.Name, &user.Email, &user.Picture, &user.Version)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return user, ErrRecordNotFound
-		}
-		return user, err
+		return user, translateError(err)
 	}
 
-	// Make nice URl to find image in
-	user.Picture = fmt.Sprintf("http://%s/static/%s", r.Host, user.Picture)
-
 	// all good? retirn user data
 	return user, nil
 }
 
+// Getting every user from the database, ordered by id
+func (m *UserModel) UsersGet(ctx context.Context) ([]*User, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UsersGet")
+	defer cancel()
+
+	q := `SELECT id, name, email, pfp_filepath, version FROM users ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Picture, &u.Version)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // Updating user info
 
 // Adding a profile picture to the server and database
-func (m *UserModel) UserUpdatePicture(picture, email string) error {
+func (m *UserModel) UserUpdatePicture(ctx context.Context, picture, email string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "UserUpdatePicture")
+	defer cancel()
+
 	// Prepare Query statment
 	q := `UPDATE users
-		SET pfp_filepath = $1
-		WHERE email = $2
-		RETURNING id`
+		SET pfp_filepath = $1, version = version + 1
+		WHERE email = $2`
 
 	args := []any{picture, email}
 
-	_ = m.DB.QueryRow(q, args...)
-
-	// insert was sucessful, carry on.
-	return nil
-
+	result, err := m.DB.ExecContext(ctx, q, args...)
+	if err != nil {
+		return translateError(err)
+	}
+	return expectRows(result)
 }
 
-// Updateing other user info using a JSON request
-func (m *UserModel) UserUpdate(u User, email string) error {
+// Updateing other user info using a JSON request. u.Version must be the version of the
+// user which the update is based on: if the user has been changed since, nothing is
+// updated and ErrEditConflict is returned. An empty u.Password leaves the password as
+// it is.
+func (m *UserModel) UserUpdate(ctx context.Context, u User, email string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "UserUpdate")
+	defer cancel()
+
 	// create query
 	q := `UPDATE users
-	set email = $1, name = $2, password_hash = $3
-	WHERE email = $4
+	set email = $1, name = $2, password_hash = COALESCE($3, password_hash), version = version + 1
+	WHERE email = $4 AND version = $5
 	RETURNING id, email, name,  password_hash, pfp_filepath`
 
-	// Generate password hash to insert into db
-	pHashed, err := Set(u.Password)
-	if err != nil {
-		return err
+	// Generate password hash to insert into db, or leave it NULL to keep the current one
+	var pHashed any
+	if u.Password != "" {
+		hash, err := hashPassword(ctx, m.Hasher, u.Password)
+		if err != nil {
+			return err
+		}
+		pHashed = hash
 	}
 
-	args := []any{u.Email, u.Name, pHashed, email}
+	args := []any{u.Email, u.Name, pHashed, email, u.Version}
 
 	// execute query
-	err = m.DB.QueryRow(q, args...).Scan(&u.ID, &u.Email, &u.Name, &u.Password, &u.Picture)
+	// ErrNoRows means there's no user with the email at that version, and a violation
+	// of "users_email_key" that the new email is taken.
+	err := m.DB.QueryRowContext(ctx, q, args...).Scan(&u.ID, &u.Email, &u.Name, &u.Password, &u.Picture)
+	if errors.Is(err, sql.ErrNoRows) {
+		return editConflict(ctx, m.DB, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, email)
+	}
 	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
-
-		}
+		return translateError(err)
 	}
 
-	// insert was sucessful, carry on.
+	// update was sucessful, carry on.
 	return nil
 }
 
-// Deleting a User by email
-func (m *UserModel) UserDelete(email string) error {
-	// prep query
-	q := `DELETE FROM users WHERE email = $1`
+// Replacing a user's password, looked up by email
+func (m *UserModel) UserUpdatePassword(ctx context.Context, email, password string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "UserUpdatePassword")
+	defer cancel()
 
-	// Ensure user isnt an admin first
-	u, _ := m.UserGet(email, http.Request{})
+	q := `UPDATE users SET password_hash = $1, version = version + 1 WHERE email = $2`
 
-	if m.IsAdmin(u.ID) {
-		return errors.New("cannot Delete admins")
+	pHashed, err := hashPassword(ctx, m.Hasher, password)
+	if err != nil {
+		return err
 	}
-	_ = m.DB.QueryRow(q, email).Scan()
-	return nil
-}
 
-// The Set() method calculates the bcrypt hash of a plaintext password
-func Set(plaintextPassword string) ([]byte, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	result, err := m.DB.ExecContext(ctx, q, pHashed, email)
 	if err != nil {
-		return nil, err
+		return translateError(err)
 	}
-
-	return hash, err
+	return expectRows(result)
 }
 
-// The Matches() method checks whether the provided plaintext password matches the
-// hashed password
-func Matches(plaintextPassword string, hash []byte) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintextPassword))
+// Deleting a User by email
+func (m *UserModel) UserDelete(ctx context.Context, email string) error {
+	ctx, cancel := m.Timeouts.context(ctx, "UserDelete")
+	defer cancel()
+
+	// prep query
+	q := `DELETE FROM users WHERE email = $1`
+
+	// Ensure user exists and isnt an admin first
+	u, err := m.UserGet(ctx, email)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
+		return err
+	}
+	isAdmin, err := m.IsAdmin(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if isAdmin {
+		return ErrCannotDeleteAdmin
+	}
+
+	// A user who still has categories assigned can't be deleted, which the foreign key
+	// on user_categories reports.
+	result, err := m.DB.ExecContext(ctx, q, email)
+	if err != nil {
+		if errors.Is(translateError(err), ErrForeignKeyViolation) {
+			return ErrRecordInUse
 		}
+		return translateError(err)
 	}
-	return true, nil
+	return expectRows(result)
+}
+
+// hashPassword hashes a password in a span, since password hashing is deliberately slow
+// and often accounts for most of the time spent handling a request.
+func hashPassword(ctx context.Context, hasher password.Hasher, plaintextPassword string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "password.Hash", trace.WithAttributes(attribute.String("password.algorithm", hasher.Algorithm)))
+	defer span.End()
+	return hasher.Hash(plaintextPassword)
 }
 
-func (m *UserModel) CheckPasswordMatches(u User, pass string) (bool, error) {
+// comparePassword checks a password against its hash in a span, like hashPassword().
+func comparePassword(ctx context.Context, plaintextPassword string, hash []byte) (bool, error) {
+	_, span := tracer.Start(ctx, "password.Compare")
+	defer span.End()
+	return password.Compare(plaintextPassword, hash)
+}
+
+func (m *UserModel) CheckPasswordMatches(ctx context.Context, u User, pass string) (bool, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "CheckPasswordMatches")
+	defer cancel()
+
 	// get users hashed password
 	q := `SELECT password_hash FROM users WHERE email = $1`
-	var hash string
-	err := m.DB.QueryRow(q, u.Email).Scan(&hash)
+	var hash []byte
+	err := m.DB.QueryRowContext(ctx, q, u.Email).Scan(&hash)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, ErrRecordNotFound
-		}
-		return false, err
+		return false, translateError(err)
 	}
-	match, err := Matches(pass, []byte(hash))
+	return comparePassword(ctx, pass, hash)
+}
+
+// UserRehashPassword replaces the user's password hash with one made by the current
+// Hasher, if the stored hash used another algorithm or parameters. It must only be called
+// with a password which has just been checked, i.e. when the user logs in, and reports
+// whether the hash was replaced. The version isn't incremented, as nothing a client can
+// see has changed, and a hash which changed in the meantime is left alone.
+func (m *UserModel) UserRehashPassword(ctx context.Context, u User, pass string) (bool, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "UserRehashPassword")
+	defer cancel()
+
+	var hash []byte
+	err := m.DB.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE email = $1`, u.Email).Scan(&hash)
+	if err != nil {
+		return false, translateError(err)
+	}
+	if !m.Hasher.NeedsRehash(hash) {
+		return false, nil
+	}
+
+	newHash, err := hashPassword(ctx, m.Hasher, pass)
 	if err != nil {
 		return false, err
 	}
-	return match, nil
+	q := `UPDATE users SET password_hash = $1 WHERE email = $2 AND password_hash = $3`
+	result, err := m.DB.ExecContext(ctx, q, newHash, u.Email, hash)
+	if err != nil {
+		return false, translateError(err)
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
 }
 
-func (m *UserModel) IsAdmin(id int) bool {
+// Checking whether a user is an admin. A user who isn't is not an error, but a failed
+// query is, so that it isn't mistaken for the user not being an admin.
+func (m *UserModel) IsAdmin(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := m.Timeouts.context(ctx, "IsAdmin")
+	defer cancel()
+
 	// prepare query
 	q := `SELECT id FROM admins WHERE id = $1`
 
-	err := m.DB.QueryRow(q, id).Scan(&id)
+	err := m.DB.QueryRowContext(ctx, q, id).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, translateError(err)
+	}
+	return true, nil
+}
+
+// Granting admin rights to a user. Granting them to someone who is already an admin is
+// not an error, granting them to a user who doesn't exist is ErrForeignKeyViolation.
+func (m *UserModel) AdminGrant(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "AdminGrant")
+	defer cancel()
+
+	// The admins table has no unique constraint, so only insert the row if it isn't
+	// there already. CAST() rather than ::bigint keeps the query valid on SQLite.
+	q := `INSERT INTO admins (id) SELECT CAST($1 AS bigint) WHERE NOT EXISTS (SELECT 1 FROM admins WHERE id = CAST($1 AS bigint))`
+
+	_, err := m.DB.ExecContext(ctx, q, id)
+	return translateError(err)
+}
+
+// Revoking admin rights from a user
+func (m *UserModel) AdminRevoke(ctx context.Context, id int) error {
+	ctx, cancel := m.Timeouts.context(ctx, "AdminRevoke")
+	defer cancel()
+
+	q := `DELETE FROM admins WHERE id = $1`
 
-	return err == nil
+	_, err := m.DB.ExecContext(ctx, q, id)
+	return err
 }
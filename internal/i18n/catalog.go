@@ -0,0 +1,158 @@
+package i18n
+
+// english holds the English messages, which every other catalog translates. Keys for
+// error responses are "error." followed by the response's problem code.
+var english = map[string]string{
+	"error.server_error":              "the server encountered a problem and could not process your request",
+	"error.not_found":                 "the requested resource could not be found",
+	"error.method_not_allowed":        "the %s method is not supported for this resource",
+	"error.invalid_credentials":       "invalid authentication credentials",
+	"error.rate_limit_exceeded":       "rate limit exceeded",
+	"error.login_locked":              "too many failed login attempts, try again in %d seconds",
+	"error.invalid_token":             "invalid or missing authentication token",
+	"error.authentication_required":   "you must be authenticated to access this resource",
+	"error.admin_required":            "you must be authenticated as an admin to access this resource",
+	"error.not_permitted":             "your user account doesn't have the necessary permissions to access this resource",
+	"error.mfa_required":              "you must enable two-factor authentication to use admin rights",
+	"error.maintenance":               "the server is undergoing maintenance, please try again later",
+	"error.feature_disabled":          "this feature is currently disabled",
+	"error.edit_conflict":             "unable to update the record due to an edit conflict, please try again",
+	"error.precondition_failed":       "the record has been modified since it was fetched, please fetch it again",
+	"error.duplicate_email":           "duplicate email",
+	"error.duplicate_category_name":   "duplicate category name",
+	"error.category_already_assigned": "category is already assigned to a user",
+	"error.record_in_use":             "record is still referenced by other records",
+	"error.cannot_delete_admin":       "cannot delete admins",
+	"error.conflict":                  "conflicts with an existing record",
+	"error.invalid_reference":         "refers to a record which does not exist",
+	"error.invalid_value":             "value is not allowed",
+
+	"request.bad_json":                "body contains badly-formed JSON",
+	"request.bad_json_at":             "body contains badly-formed JSON (at character %d)",
+	"request.wrong_json_type":         "body contains incorrect JSON type (at character %d)",
+	"request.wrong_json_field_type":   "body contains incorrect JSON type for field %q",
+	"request.empty_body":              "body must not be empty",
+	"request.unknown_key":             "body contains unknown key %s",
+	"request.body_too_large":          "body must not be larger than %d bytes",
+	"request.multiple_json_values":    "body must only contain a single JSON value",
+	"request.me_unauthenticated":      "must be authenticated to refer to yourself",
+	"request.invalid_user_parameter":  "invalid user parameter",
+	"request.invalid_email_parameter": "invalid email parameter",
+	"request.invalid_id_parameter":    "invalid id parameter",
+
+	"validation.required":   "Must be provided",
+	"validation.email":      "Must be a valid email address",
+	"validation.min":        "Must be at least %v",
+	"validation.max":        "Must be a maximum of %v",
+	"validation.min_length": "Must be at least %v characters long",
+	"validation.max_length": "Must be at most %v characters long",
+	"validation.min_items":  "Must contain at least %v items",
+	"validation.max_items":  "Must contain at most %v items",
+	"validation.oneof":      "Must be one of %s",
+	"validation.eqfield":    "Must match %s",
+	"validation.nefield":    "Must be different from %s",
+	"validation.max_bytes":  "Must not be longer than %v bytes",
+	"validation.classes":    "Must use at least %v of lowercase letters, uppercase letters, digits and symbols",
+	"validation.personal":   "Must not contain your name or email address",
+	"validation.breached":   "Has appeared in a data breach, please choose another",
+	"validation.weak":       "Is too weak (strength %v, at least %v is required)",
+
+	"validation.name.required":             "Name must be provided",
+	"validation.email.required":            "Email must be provided",
+	"validation.email.email":               "Email must be a valid address",
+	"validation.password.required":         "Password must be provided",
+	"validation.password.min_length":       "Password must be at least %v characters long",
+	"validation.password.max_bytes":        "Password must not be longer than %v bytes",
+	"validation.password.classes":          "Password must use at least %v of lowercase letters, uppercase letters, digits and symbols",
+	"validation.password.personal":         "Password must not contain your name or email address",
+	"validation.password.breached":         "Password has appeared in a data breach, please choose another",
+	"validation.password.weak":             "Password is too weak (strength %v, at least %v is required)",
+	"validation.current_password.required": "Current password must be provided to change the password",
+	"validation.current_password.wrong":    "Current password is incorrect",
+	"validation.code.required":             "A code or recovery code must be provided",
+	"validation.code.wrong":                "Code is incorrect",
+	"validation.image.type":                "Image must be a JPEG or PNG file",
+	"validation.integer":                   "Must be an integer value",
+	"validation.page.min":                  "Page must be at least %v",
+	"validation.page.max":                  "Page must be a maximum of %v",
+	"validation.page_size.min":             "Page size must be at least %v",
+	"validation.page_size.max":             "Page size must be a maximum of %v",
+}
+
+// arabic holds the Arabic translations of the English messages.
+var arabic = map[string]string{
+	"error.server_error":              "واجه الخادم مشكلة ولم يتمكن من معالجة طلبك",
+	"error.not_found":                 "تعذر العثور على المورد المطلوب",
+	"error.method_not_allowed":        "الطريقة %s غير مدعومة لهذا المورد",
+	"error.invalid_credentials":       "بيانات تسجيل الدخول غير صحيحة",
+	"error.rate_limit_exceeded":       "تم تجاوز الحد المسموح به من الطلبات",
+	"error.login_locked":              "محاولات تسجيل دخول فاشلة كثيرة جدًا، حاول مرة أخرى بعد %d ثانية",
+	"error.invalid_token":             "رمز المصادقة غير صالح أو مفقود",
+	"error.authentication_required":   "يجب تسجيل الدخول للوصول إلى هذا المورد",
+	"error.admin_required":            "يجب تسجيل الدخول كمسؤول للوصول إلى هذا المورد",
+	"error.not_permitted":             "لا يملك حسابك الصلاحيات اللازمة للوصول إلى هذا المورد",
+	"error.mfa_required":              "يجب تفعيل المصادقة الثنائية لاستخدام صلاحيات المسؤول",
+	"error.maintenance":               "الخادم قيد الصيانة، يرجى المحاولة مرة أخرى لاحقًا",
+	"error.feature_disabled":          "هذه الميزة معطلة حاليًا",
+	"error.edit_conflict":             "تعذر تحديث السجل بسبب تعارض في التعديل، يرجى المحاولة مرة أخرى",
+	"error.precondition_failed":       "تم تعديل السجل منذ جلبه، يرجى جلبه مرة أخرى",
+	"error.duplicate_email":           "البريد الإلكتروني مستخدم بالفعل",
+	"error.duplicate_category_name":   "اسم التصنيف مستخدم بالفعل",
+	"error.category_already_assigned": "التصنيف مُسند بالفعل إلى مستخدم",
+	"error.record_in_use":             "السجل لا يزال مرتبطًا بسجلات أخرى",
+	"error.cannot_delete_admin":       "لا يمكن حذف المسؤولين",
+	"error.conflict":                  "يتعارض مع سجل موجود",
+	"error.invalid_reference":         "يشير إلى سجل غير موجود",
+	"error.invalid_value":             "القيمة غير مسموح بها",
+
+	"request.bad_json":                "يحتوي نص الطلب على JSON غير سليم",
+	"request.bad_json_at":             "يحتوي نص الطلب على JSON غير سليم (عند الحرف %d)",
+	"request.wrong_json_type":         "يحتوي نص الطلب على نوع JSON غير صحيح (عند الحرف %d)",
+	"request.wrong_json_field_type":   "يحتوي نص الطلب على نوع JSON غير صحيح للحقل %q",
+	"request.empty_body":              "يجب ألا يكون نص الطلب فارغًا",
+	"request.unknown_key":             "يحتوي نص الطلب على مفتاح غير معروف %s",
+	"request.body_too_large":          "يجب ألا يتجاوز حجم نص الطلب %d بايت",
+	"request.multiple_json_values":    "يجب أن يحتوي نص الطلب على قيمة JSON واحدة فقط",
+	"request.me_unauthenticated":      "يجب تسجيل الدخول للإشارة إلى نفسك",
+	"request.invalid_user_parameter":  "معامل المستخدم غير صالح",
+	"request.invalid_email_parameter": "معامل البريد الإلكتروني غير صالح",
+	"request.invalid_id_parameter":    "معامل المعرّف غير صالح",
+
+	"validation.required":   "يجب إدخال هذه القيمة",
+	"validation.email":      "يجب أن يكون عنوان بريد إلكتروني صالحًا",
+	"validation.min":        "يجب ألا تقل القيمة عن %v",
+	"validation.max":        "يجب ألا تتجاوز القيمة %v",
+	"validation.min_length": "يجب ألا يقل الطول عن %v أحرف",
+	"validation.max_length": "يجب ألا يتجاوز الطول %v حرفًا",
+	"validation.min_items":  "يجب أن يحتوي على %v عناصر على الأقل",
+	"validation.max_items":  "يجب ألا يحتوي على أكثر من %v عناصر",
+	"validation.oneof":      "يجب أن تكون القيمة إحدى القيم التالية: %s",
+	"validation.eqfield":    "يجب أن تطابق %s",
+	"validation.nefield":    "يجب أن تختلف عن %s",
+	"validation.max_bytes":  "يجب ألا يتجاوز الطول %v بايت",
+	"validation.classes":    "يجب أن تستخدم %v على الأقل من الأنواع التالية: أحرف صغيرة، أحرف كبيرة، أرقام، رموز",
+	"validation.personal":   "يجب ألا تحتوي على اسمك أو بريدك الإلكتروني",
+	"validation.breached":   "ظهرت هذه القيمة في تسريب بيانات، يرجى اختيار قيمة أخرى",
+	"validation.weak":       "ضعيفة جدًا (القوة %v، والمطلوب %v على الأقل)",
+
+	"validation.name.required":             "يجب إدخال الاسم",
+	"validation.email.required":            "يجب إدخال البريد الإلكتروني",
+	"validation.email.email":               "يجب أن يكون البريد الإلكتروني عنوانًا صالحًا",
+	"validation.password.required":         "يجب إدخال كلمة المرور",
+	"validation.password.min_length":       "يجب ألا تقل كلمة المرور عن %v أحرف",
+	"validation.password.max_bytes":        "يجب ألا يتجاوز طول كلمة المرور %v بايت",
+	"validation.password.classes":          "يجب أن تستخدم كلمة المرور %v على الأقل من الأنواع التالية: أحرف صغيرة، أحرف كبيرة، أرقام، رموز",
+	"validation.password.personal":         "يجب ألا تحتوي كلمة المرور على اسمك أو بريدك الإلكتروني",
+	"validation.password.breached":         "ظهرت كلمة المرور هذه في تسريب بيانات، يرجى اختيار كلمة مرور أخرى",
+	"validation.password.weak":             "كلمة المرور ضعيفة جدًا (القوة %v، والمطلوب %v على الأقل)",
+	"validation.current_password.required": "يجب إدخال كلمة المرور الحالية لتغيير كلمة المرور",
+	"validation.current_password.wrong":    "كلمة المرور الحالية غير صحيحة",
+	"validation.code.required":             "يجب إدخال رمز أو رمز استرداد",
+	"validation.code.wrong":                "الرمز غير صحيح",
+	"validation.image.type":                "يجب أن تكون الصورة بصيغة JPEG أو PNG",
+	"validation.integer":                   "يجب أن تكون القيمة عددًا صحيحًا",
+	"validation.page.min":                  "يجب ألا يقل رقم الصفحة عن %v",
+	"validation.page.max":                  "يجب ألا يتجاوز رقم الصفحة %v",
+	"validation.page_size.min":             "يجب ألا يقل حجم الصفحة عن %v",
+	"validation.page_size.max":             "يجب ألا يتجاوز حجم الصفحة %v",
+}
@@ -0,0 +1,123 @@
+// Package i18n holds the catalogs of messages returned to clients, and picks the
+// language to return them in from the request's Accept-Language header.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// The languages with a message catalog. English is the default, used when the client
+// doesn't ask for a language we support.
+var (
+	English = language.English
+	Arabic  = language.Arabic
+
+	supported = []language.Tag{English, Arabic}
+	matcher   = language.NewMatcher(supported)
+)
+
+// catalogs maps each supported language to its messages, keyed by message key. The
+// messages are fmt format strings.
+var catalogs = map[language.Tag]map[string]string{
+	English: english,
+	Arabic:  arabic,
+}
+
+// rtl lists the languages written right to left.
+var rtl = map[language.Tag]bool{Arabic: true}
+
+// Unicode's first strong isolate and pop directional isolate characters. Values
+// interpolated into a right-to-left message are wrapped in them, so that a left-to-right
+// value such as an email address or a JSON key is laid out on its own, rather than
+// reordering the message around it.
+const (
+	firstStrongIsolate    = "\u2068"
+	popDirectionalIsolate = "\u2069"
+)
+
+// Negotiate returns the supported language which best matches an Accept-Language header,
+// or English if none do.
+func Negotiate(acceptLanguage string) language.Tag {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, index, _ := matcher.Match(tags...)
+	return supported[index]
+}
+
+// Message returns the message for key in the given language, formatted with args. A key
+// missing from the language's catalog falls back to English, and a key missing from
+// that is returned as it is.
+//
+// The validator reports a failed rule with the key "validation.<field>.<rule>", so that
+// a field can have its own message. If there isn't one, the rule's generic
+// "validation.<rule>" message is used.
+func Message(tag language.Tag, key string, args ...any) string {
+	format, tag, ok := lookup(tag, key)
+	if !ok {
+		field, rule, found := strings.Cut(strings.TrimPrefix(key, "validation."), ".")
+		if !strings.HasPrefix(key, "validation.") || !found || field == "" {
+			return key
+		}
+		if format, tag, ok = lookup(tag, "validation."+rule); !ok {
+			return key
+		}
+	}
+	if rtl[tag] {
+		args = isolate(args)
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// lookup returns the message for key in the given language, or in English if the
+// language doesn't have it, along with the language of the message.
+func lookup(tag language.Tag, key string) (string, language.Tag, bool) {
+	if format, ok := catalogs[tag][key]; ok {
+		return format, tag, true
+	}
+	format, ok := english[key]
+	return format, English, ok
+}
+
+// AddMessages adds messages to the catalog for a supported language, such as the
+// messages for a rule registered with the validator. Like the rules, messages must be
+// added before any requests are served.
+func AddMessages(tag language.Tag, messages map[string]string) {
+	catalog, ok := catalogs[tag]
+	if !ok {
+		panic(fmt.Sprintf("i18n: no catalog for %s", tag))
+	}
+	for key, format := range messages {
+		catalog[key] = format
+	}
+}
+
+// isolate returns a copy of args with the strings wrapped in directional isolates.
+// Numbers are left alone so that they still format with verbs such as %d.
+func isolate(args []any) []any {
+	isolated := make([]any, len(args))
+	for i, arg := range args {
+		if s, ok := arg.(string); ok {
+			arg = firstStrongIsolate + s + popDirectionalIsolate
+		}
+		isolated[i] = arg
+	}
+	return isolated
+}
+
+// Error is an error whose message can be translated. Its Error() method returns the
+// English message, for logs and for code which doesn't know the client's language.
+type Error struct {
+	Key  string
+	Args []any
+}
+
+// NewError returns an Error for the message with the given key and arguments.
+func NewError(key string, args ...any) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+func (e *Error) Error() string {
+	return Message(English, e.Key, e.Args...)
+}
@@ -0,0 +1,82 @@
+package i18n
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+// verbRX matches the fmt verbs in a message.
+var verbRX = regexp.MustCompile(`%[a-z]`)
+
+// TestCatalogs checks that every English message is translated, and that the
+// translations take the same arguments.
+func TestCatalogs(t *testing.T) {
+	for tag, catalog := range catalogs {
+		for key, format := range english {
+			translated, ok := catalog[key]
+			if !ok {
+				t.Errorf("%s has no message for %q", tag, key)
+				continue
+			}
+			want := verbRX.FindAllString(format, -1)
+			if got := verbRX.FindAllString(translated, -1); !slices.Equal(got, want) {
+				t.Errorf("%s message for %q has verbs %v; want %v", tag, key, got, want)
+			}
+		}
+		for key := range catalog {
+			if _, ok := english[key]; !ok {
+				t.Errorf("%s has a message for unknown key %q", tag, key)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           language.Tag
+	}{
+		{"", English},
+		{"ar", Arabic},
+		{"ar-SA,ar;q=0.9,en;q=0.8", Arabic},
+		{"en-GB,en;q=0.9,ar;q=0.5", English},
+		{"fr-FR,ar;q=0.5", Arabic},
+		{"fr-FR", English},
+		{"not a language", English},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.acceptLanguage); got != tt.want {
+			t.Errorf("Negotiate(%q) = %s; want %s", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  language.Tag
+		key  string
+		args []any
+		want string
+	}{
+		{"English", English, "error.method_not_allowed", []any{"PATCH"}, "the PATCH method is not supported for this resource"},
+		{"Arabic isolates strings", Arabic, "error.method_not_allowed", []any{"PATCH"}, "الطريقة \u2068PATCH\u2069 غير مدعومة لهذا المورد"},
+		{"Arabic leaves numbers", Arabic, "validation.page_size.max", []any{100}, "يجب ألا يتجاوز حجم الصفحة 100"},
+		{"Unsupported language", language.French, "error.not_found", nil, "the requested resource could not be found"},
+		{"Generic rule message", English, "validation.tags.max_items", []any{5}, "Must contain at most 5 items"},
+		{"Field rule message", Arabic, "validation.password.min_length", []any{8}, "يجب ألا تقل كلمة المرور عن 8 أحرف"},
+		{"Unknown key", Arabic, "no.such.key", nil, "no.such.key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Message(tt.tag, tt.key, tt.args...); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package password
+
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minBenchmarkMemory is the least memory Benchmark() lowers argon2id to when even a
+// single iteration takes too long. Below it, argon2id's resistance to GPU attacks is
+// hardly better than bcrypt's.
+const minBenchmarkMemory = 19 * 1024
+
+// Benchmark recommends the strongest parameters for the algorithm which still hash a
+// password in about the target time on this host, returning the hasher and the time
+// its hashes took. The recommendation depends on the machine it runs on, so it should
+// be run on the servers (or identical ones) which will use it.
+//
+// For argon2id, memory is the most effective defence against cracking hardware, so it
+// is fixed at maxMemory (in KiB) and the iterations are increased until the next one
+// would exceed the target; if a single iteration already does, the memory is halved
+// instead, down to 19 MiB. For bcrypt, the cost is the highest whose hashes take no
+// longer than the target, and at least 10.
+func Benchmark(algorithm string, target time.Duration, maxMemory uint32, parallelism uint8) (Hasher, time.Duration, error) {
+	h := DefaultHasher()
+	h.Algorithm = algorithm
+	h.Argon2.Memory = maxMemory
+	h.Argon2.Iterations = 1
+	h.Argon2.Parallelism = parallelism
+	h.BcryptCost = 10
+	if err := h.Validate(); err != nil {
+		return h, 0, err
+	}
+
+	elapsed := measure(h)
+	if algorithm == Bcrypt {
+		for h.BcryptCost < bcrypt.MaxCost {
+			next := h
+			next.BcryptCost++
+			// Each step doubles the time, so stop before measuring a cost which is
+			// bound to be too slow.
+			if 2*elapsed > target {
+				break
+			}
+			h, elapsed = next, measure(next)
+		}
+		return h, elapsed, nil
+	}
+
+	for elapsed > target && h.Argon2.Memory/2 >= minBenchmarkMemory {
+		h.Argon2.Memory /= 2
+		elapsed = measure(h)
+	}
+	for elapsed <= target {
+		next := h
+		next.Argon2.Iterations++
+		nextElapsed := measure(next)
+		if nextElapsed > target {
+			break
+		}
+		h, elapsed = next, nextElapsed
+	}
+	return h, elapsed, nil
+}
+
+// measure returns how long the hasher takes to hash a password, the fastest of two runs
+// so that a one-off pause doesn't skew the result.
+func measure(h Hasher) time.Duration {
+	var fastest time.Duration
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		h.Hash("correct horse battery staple")
+		if elapsed := time.Since(start); i == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return fastest
+}
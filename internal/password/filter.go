@@ -0,0 +1,137 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+//go:generate go run gen_filter.go -in common.txt -out breached.bloom
+
+// breachedFilter is a Filter of commonly used passwords which have appeared in data
+// breaches, generated from common.txt. Deployments can load a larger list with
+// ReadFilter instead.
+//
+//go:embed breached.bloom
+var breachedFilter []byte
+
+// filterMagic starts every encoded Filter.
+var filterMagic = [4]byte{'P', 'W', 'B', 'F'}
+
+// A Filter is a Bloom filter of passwords: a compact set which can say for sure that a
+// password isn't in it, but may wrongly say that one is, at a rate chosen when it is
+// built. Wrongly rejecting a handful of passwords is a fair price for bundling a large
+// breached password list in a few kilobytes.
+type Filter struct {
+	k    uint32   // the number of bits set per password
+	bits []uint64 // the bit array, whose length is a multiple of 64
+}
+
+// NewFilter returns an empty Filter sized for n passwords with a false positive rate of
+// about p.
+func NewFilter(n int, p float64) *Filter {
+	n = max(n, 1)
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return &Filter{
+		k:    uint32(max(k, 1)),
+		bits: make([]uint64, (int(m)+63)/64),
+	}
+}
+
+// Add adds a password to the filter.
+func (f *Filter) Add(password string) {
+	f.each(password, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+// Contains reports whether the password is probably in the filter.
+func (f *Filter) Contains(password string) bool {
+	return f.each(password, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+// each calls fn with each of the k bits for the password, using double hashing of a
+// SHA-256 digest, until fn returns false. It returns false if fn did.
+func (f *Filter) each(password string, fn func(bit uint64) bool) bool {
+	sum := sha256.Sum256([]byte(password))
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		if !fn((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes the filter in the form read by ReadFilter.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 16)
+	copy(header, filterMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], f.k)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(f.bits)))
+	bw.Write(header)
+	for _, word := range f.bits {
+		bw.Write(binary.LittleEndian.AppendUint64(nil, word))
+	}
+	return int64(16 + 8*len(f.bits)), bw.Flush()
+}
+
+// ReadFilter reads a filter written by WriteTo.
+func ReadFilter(r io.Reader) (*Filter, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("password filter: %w", err)
+	}
+	if [4]byte(header[:4]) != filterMagic {
+		return nil, errors.New("password filter: not a password filter")
+	}
+	f := &Filter{k: binary.LittleEndian.Uint32(header[4:8])}
+	words := binary.LittleEndian.Uint64(header[8:16])
+	if f.k == 0 || words == 0 || words > 1<<28 {
+		return nil, errors.New("password filter: invalid header")
+	}
+
+	buf := make([]byte, 8*words)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("password filter: %w", err)
+	}
+	f.bits = make([]uint64, words)
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+	return f, nil
+}
+
+// LoadFilter reads a filter from a file.
+func LoadFilter(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFilter(bufio.NewReader(file))
+}
+
+// Breached returns the bundled filter of breached passwords.
+var Breached = sync.OnceValue(func() *Filter {
+	f, err := ReadFilter(bytes.NewReader(breachedFilter))
+	if err != nil {
+		panic(err)
+	}
+	return f
+})
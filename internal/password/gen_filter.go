@@ -0,0 +1,54 @@
+//go:build ignore
+
+// gen_filter builds the bundled breached password filter from a list of passwords, one
+// per line. It can also be used to build a filter from a larger list, which the API
+// loads with the password-breached-filter setting:
+//
+//	go run gen_filter.go -in passwords.txt -out breached.bloom -fp 0.001
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"interview_assignment.mohamednaas.net/internal/password"
+)
+
+func main() {
+	in := flag.String("in", "common.txt", "File of passwords, one per line")
+	out := flag.String("out", "breached.bloom", "File to write the filter to")
+	fp := flag.Float64("fp", 0.001, "False positive rate")
+	flag.Parse()
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var passwords []string
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			passwords = append(passwords, line)
+		}
+	}
+
+	filter := password.NewFilter(len(passwords), *fp)
+	for _, p := range passwords {
+		filter.Add(p)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := filter.WriteTo(file); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d passwords to %s", len(passwords), *out)
+}
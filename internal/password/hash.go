@@ -0,0 +1,213 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The hashing algorithms, as named in the configuration and in the hashes themselves.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// ErrUnknownHash is returned for a stored hash in a format Compare() doesn't recognise.
+var ErrUnknownHash = errors.New("password: unknown hash format")
+
+// Argon2Params are the argon2id parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// A Hasher hashes new passwords with the configured algorithm and parameters. Every
+// hash records the algorithm and parameters it was made with, so that it can still be
+// checked after the configuration changes, and NeedsRehash() can tell which hashes are
+// out of date.
+//
+// argon2id hashes use the PHC string format shared with other implementations, e.g.
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>, and bcrypt hashes use bcrypt's own
+// format, e.g. $2a$12$<salt and key>, which is what existing databases hold.
+type Hasher struct {
+	// Algorithm is Argon2id or Bcrypt.
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+// DefaultHasher returns the hasher used when none is configured: argon2id with the
+// parameters recommended by OWASP, 19 MiB of memory and 2 iterations.
+func DefaultHasher() Hasher {
+	return Hasher{
+		Algorithm: Argon2id,
+		Argon2: Argon2Params{
+			Memory:      19 * 1024,
+			Iterations:  2,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: 12,
+	}
+}
+
+// Validate reports whether the hasher's settings are usable.
+func (h Hasher) Validate() error {
+	switch h.Algorithm {
+	case Argon2id:
+		p := h.Argon2
+		switch {
+		case p.Iterations < 1:
+			return errors.New("argon2id iterations must be at least 1")
+		case p.Parallelism < 1:
+			return errors.New("argon2id parallelism must be at least 1")
+		case p.Memory < 8*uint32(p.Parallelism):
+			return errors.New("argon2id memory must be at least 8 KiB per thread")
+		case p.SaltLength < 8 || p.KeyLength < 16:
+			return errors.New("argon2id salts must be at least 8 bytes and keys at least 16")
+		}
+	case Bcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q, must be %s or %s", h.Algorithm, Argon2id, Bcrypt)
+	}
+	return nil
+}
+
+// Hash returns the hash of a plaintext password.
+func (h Hasher) Hash(plaintext string) ([]byte, error) {
+	if h.Algorithm == Bcrypt {
+		return bcrypt.GenerateFromPassword([]byte(plaintext), h.BcryptCost)
+	}
+
+	p := h.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(plaintext), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	b64 := base64.RawStdEncoding
+	return []byte(fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key))), nil
+}
+
+// NeedsRehash reports whether a stored hash was made with a different algorithm than
+// the hasher's, or with weaker parameters, and should be replaced the next time the
+// plaintext is known, i.e. when the user logs in. Hashes made with stronger parameters
+// are kept, so that lowering the configured cost doesn't weaken the existing hashes.
+func (h Hasher) NeedsRehash(hash []byte) bool {
+	switch algorithm(hash) {
+	case Argon2id:
+		p, _, _, err := decodeArgon2(hash)
+		return err != nil || h.Algorithm != Argon2id || p.weakerThan(h.Argon2)
+	case Bcrypt:
+		cost, err := bcrypt.Cost(hash)
+		return err != nil || h.Algorithm != Bcrypt || cost < h.BcryptCost
+	}
+	return true
+}
+
+// weakerThan reports whether any of the parameters are below the ones in other.
+func (p Argon2Params) weakerThan(other Argon2Params) bool {
+	return p.Memory < other.Memory ||
+		p.Iterations < other.Iterations ||
+		p.Parallelism < other.Parallelism ||
+		p.SaltLength < other.SaltLength ||
+		p.KeyLength < other.KeyLength
+}
+
+// Compare reports whether a plaintext password matches a hash made by any Hasher,
+// whatever its settings. It returns ErrUnknownHash if the hash isn't in a known format.
+func Compare(plaintext string, hash []byte) (bool, error) {
+	switch algorithm(hash) {
+	case Argon2id:
+		p, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(plaintext), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case Bcrypt:
+		// bcrypt ignores everything after 72 bytes, which would let a longer password
+		// match when only its beginning is right.
+		if len(plaintext) > bcryptMaxBytes {
+			return false, nil
+		}
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return false, ErrUnknownHash
+}
+
+// dummyHashes caches the hash CompareDummy() compares against for each Hasher.
+var dummyHashes sync.Map // Hasher -> []byte
+
+// CompareDummy takes as long as comparing the plaintext against a hash made by the
+// hasher, but without a hash to compare it with. A login naming an unknown account runs
+// it in place of the real comparison, so that the quicker response doesn't give away
+// that the account doesn't exist.
+func (h Hasher) CompareDummy(plaintext string) {
+	hash, ok := dummyHashes.Load(h)
+	if !ok {
+		dummy, err := h.Hash("dummy password")
+		if err != nil {
+			return
+		}
+		hash, _ = dummyHashes.LoadOrStore(h, dummy)
+	}
+	Compare(plaintext, hash.([]byte))
+}
+
+// algorithm returns the algorithm a hash was made with, or "" if it isn't recognised.
+func algorithm(hash []byte) string {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$"+Argon2id+"$")):
+		return Argon2id
+	case bytes.HasPrefix(hash, []byte("$2a$")), bytes.HasPrefix(hash, []byte("$2b$")),
+		bytes.HasPrefix(hash, []byte("$2y$")):
+		return Bcrypt
+	}
+	return ""
+}
+
+// decodeArgon2 parses an argon2id hash in the PHC string format.
+func decodeArgon2(hash []byte) (p Argon2Params, salt, key []byte, err error) {
+	fields := strings.Split(string(hash), "$")
+	if len(fields) != 6 {
+		return p, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("password: unsupported argon2 version %q", fields[2])
+	}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, fmt.Errorf("password: invalid argon2 parameters %q", fields[3])
+	}
+
+	b64 := base64.RawStdEncoding
+	if salt, err = b64.DecodeString(fields[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("password: invalid argon2 salt: %w", err)
+	}
+	if key, err = b64.DecodeString(fields[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("password: invalid argon2 key: %w", err)
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
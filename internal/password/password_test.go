@@ -0,0 +1,191 @@
+package password
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"interview_assignment.mohamednaas.net/internal/validator"
+)
+
+func TestFilter(t *testing.T) {
+	f := NewFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("password-%d", i))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !read.Contains(fmt.Sprintf("password-%d", i)) {
+			t.Fatalf("filter doesn't contain password-%d", i)
+		}
+	}
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if read.Contains(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("got %d false positives in 10000; want about 100", falsePositives)
+	}
+
+	if _, err := ReadFilter(strings.NewReader("not a filter at all")); err == nil {
+		t.Error("reading an invalid filter didn't fail")
+	}
+}
+
+func TestBreached(t *testing.T) {
+	for _, p := range []string{"password", "123456", "qwerty123", "Password1"} {
+		if !Breached().Contains(p) {
+			t.Errorf("bundled filter doesn't contain %q", p)
+		}
+	}
+	if Breached().Contains("pa55word1234") {
+		t.Error("bundled filter contains the password used by the tests")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.MinClasses = 2
+	policy.MinScore = 2
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"Valid", "correct-horse-battery", ""},
+		{"Too short", "a1b2c3", "validation.password.min_length"},
+		{"Too long for bcrypt", strings.Repeat("a1", 40), "validation.password.max_bytes"},
+		{"One class", "correcthorsebattery", "validation.password.classes"},
+		{"Contains name", "alice-in-chains-99", "validation.password.personal"},
+		{"Contains email", "xx-asmith-1234", "validation.password.personal"},
+		{"Breached", "password123", "validation.password.breached"},
+		{"Breached in another case", "PASSWORD123", "validation.password.breached"},
+		{"Weak", "aaaaaaaa1", "validation.password.weak"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			policy.Validate(v, "password", tt.password, "Alice Smith", "asmith@example.com")
+			if got := v.Errors["password"].Key; got != tt.want {
+				t.Errorf("got error %q; want %q", got, tt.want)
+			}
+			if _, ok := v.Details["password_strength"].(Strength); ok == (tt.want == "") {
+				t.Errorf("got details %v; want the strength only for rejected passwords", v.Details)
+			}
+		})
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password string
+		want     Strength
+	}{
+		{"", Strength{}},
+		{"password", Strength{}},
+		{"mnopqrst", Strength{Score: 0, Entropy: 11}},
+		{"pa55word1234", Strength{Score: 2, Entropy: 45}},
+		{"correct-horse-battery", Strength{Score: 4, Entropy: 104}},
+	}
+
+	for _, tt := range tests {
+		if got := Estimate(tt.password, Breached()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Estimate(%q) = %+v; want %+v", tt.password, got, tt.want)
+		}
+	}
+
+	// Personal information counts for little.
+	if with, without := Estimate("alicesmith99", nil, "Alice Smith"), Estimate("alicesmith99", nil); with.Entropy >= without.Entropy {
+		t.Errorf("got entropy %d with the name and %d without; want less with it", with.Entropy, without.Entropy)
+	}
+}
+
+func TestHasher(t *testing.T) {
+	argon := DefaultHasher()
+	argon.Argon2.Memory = 64
+	argon.Argon2.Iterations = 1
+	bcryptHasher := argon
+	bcryptHasher.Algorithm = Bcrypt
+	bcryptHasher.BcryptCost = 4
+
+	for _, h := range []Hasher{argon, bcryptHasher} {
+		t.Run(h.Algorithm, func(t *testing.T) {
+			hash, err := h.Hash("pa55word1234")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range []struct {
+				plaintext string
+				want      bool
+			}{
+				{"pa55word1234", true},
+				{"pa55word12345", false},
+				{"", false},
+			} {
+				if got, err := Compare(tt.plaintext, hash); err != nil || got != tt.want {
+					t.Errorf("Compare(%q) = %t, %v; want %t", tt.plaintext, got, err, tt.want)
+				}
+			}
+			if h.NeedsRehash(hash) {
+				t.Errorf("hash %s needs rehashing with the hasher which made it", hash)
+			}
+		})
+	}
+
+	argonHash, _ := argon.Hash("pa55word1234")
+	bcryptHash, _ := bcryptHasher.Hash("pa55word1234")
+	stronger := argon
+	stronger.Argon2.Iterations++
+	costlier := bcryptHasher
+	costlier.BcryptCost++
+	weaker := argon
+	weaker.Argon2.Memory /= 2
+	cheaper := bcryptHasher
+	cheaper.BcryptCost--
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   []byte
+		want   bool
+	}{
+		{"Same argon2id parameters", argon, argonHash, false},
+		{"More argon2id iterations", stronger, argonHash, true},
+		{"bcrypt to argon2id", argon, bcryptHash, true},
+		{"argon2id to bcrypt", bcryptHasher, argonHash, true},
+		{"Higher bcrypt cost", costlier, bcryptHash, true},
+		// Lowering the settings mustn't weaken the hashes already made.
+		{"Less argon2id memory", weaker, argonHash, false},
+		{"Lower bcrypt cost", cheaper, bcryptHash, false},
+		{"Unknown format", argon, []byte("plaintext"), true},
+	}
+	for _, tt := range tests {
+		if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %t; want %t", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Compare("pa55word1234", []byte("plaintext")); err != ErrUnknownHash {
+		t.Errorf("comparing with an unknown hash returned %v; want ErrUnknownHash", err)
+	}
+	// bcrypt ignores anything after 72 bytes, which mustn't let a longer password in.
+	long := strings.Repeat("a", 72)
+	longHash, _ := bcryptHasher.Hash(long)
+	if match, _ := Compare(long+"b", longHash); match {
+		t.Error("a password longer than 72 bytes matched the hash of its first 72 bytes")
+	}
+}
@@ -0,0 +1,86 @@
+// Package password holds the rules new passwords must follow: the configurable policy,
+// the check against passwords known from data breaches, and the strength estimate sent
+// back when a password is rejected. It also hashes passwords for storage, see Hasher.
+package password
+
+import (
+	"strings"
+
+	"interview_assignment.mohamednaas.net/internal/validator"
+)
+
+// bcryptMaxBytes is the length bcrypt truncates passwords to. Anything after it is
+// silently ignored, so longer passwords are rejected rather than given a false sense of
+// security.
+const bcryptMaxBytes = 72
+
+// Policy describes the passwords which are accepted.
+type Policy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int
+	// MaxBytes is the maximum length in bytes, which can't be more than bcrypt's limit
+	// when passwords are hashed with bcrypt.
+	MaxBytes int
+	// MinClasses is the number of character classes (lowercase letters, uppercase
+	// letters, digits, symbols and other letters) the password must use.
+	MinClasses int
+	// ForbidPersonal rejects passwords containing the user's name or the local part of
+	// their email address.
+	ForbidPersonal bool
+	// MinScore is the lowest accepted Estimate() score, from 0 to 4.
+	MinScore int
+	// Breached is the filter of breached passwords to reject, or nil to skip the check.
+	Breached *Filter
+}
+
+// DefaultPolicy returns the policy used when none is configured, which checks against
+// the bundled list of breached passwords.
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:      8,
+		MaxBytes:       bcryptMaxBytes,
+		MinClasses:     1,
+		ForbidPersonal: true,
+		Breached:       Breached(),
+	}
+}
+
+// Validate checks a new password against the policy, adding any failure to v under
+// field. The personal information is the user's name and email address. When the
+// password is rejected, its estimated strength is added to v.Details as
+// "password_strength".
+func (p Policy) Validate(v *validator.Validator, field, password string, personal ...string) {
+	if _, exists := v.Errors[field]; exists {
+		return
+	}
+
+	length := len([]rune(password))
+	lower := strings.ToLower(password)
+	strength := Estimate(password, p.Breached, personal...)
+	switch {
+	case length < p.MinLength:
+		v.AddError(field, "validation."+field+".min_length", p.MinLength)
+	case len(password) > p.MaxBytes:
+		v.AddError(field, "validation."+field+".max_bytes", p.MaxBytes)
+	case countClasses(password) < p.MinClasses:
+		v.AddError(field, "validation."+field+".classes", p.MinClasses)
+	case p.ForbidPersonal && containsAny(lower, personalTokens(personal)):
+		v.AddError(field, "validation."+field+".personal")
+	case p.Breached != nil && isBreached(p.Breached, password):
+		v.AddError(field, "validation."+field+".breached")
+	case strength.Score < p.MinScore:
+		v.AddError(field, "validation."+field+".weak", strength.Score, p.MinScore)
+	default:
+		return
+	}
+	v.AddDetail(field+"_strength", strength)
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
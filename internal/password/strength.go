@@ -0,0 +1,151 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength is a rough estimate of how hard a password is to guess, returned to clients
+// alongside a rejected password so that they can show a strength meter.
+type Strength struct {
+	// Score runs from 0 (trivial to guess) to 4 (very hard to guess).
+	Score int `json:"score"`
+	// Entropy is the estimated number of bits of randomness in the password.
+	Entropy int `json:"entropy_bits"`
+}
+
+// The entropy thresholds for each score above 0.
+var scoreThresholds = []float64{28, 36, 60, 80}
+
+// Estimate returns the strength of a password. It starts from the bits needed to pick
+// each character at random from the character classes used, then discounts characters
+// which repeat or continue a sequence of the one before (aaa, abc, 321), the characters
+// of any personal information (such as the user's name) the password contains, and
+// passwords in the breached filter, which are guessed first by attackers.
+func Estimate(password string, breached *Filter, personal ...string) Strength {
+	if password == "" || (breached != nil && isBreached(breached, password)) {
+		return Strength{}
+	}
+
+	runes := []rune(password)
+	perChar := math.Log2(float64(charsetSize(password)))
+	var bits float64
+	for i, r := range runes {
+		if i > 0 && abs(r-runes[i-1]) <= 1 {
+			bits++
+			continue
+		}
+		bits += perChar
+	}
+
+	lower := strings.ToLower(password)
+	for _, p := range personalTokens(personal) {
+		if strings.Contains(lower, p) {
+			bits -= float64(len([]rune(p))-1) * perChar
+		}
+	}
+	bits = max(bits, 0)
+
+	s := Strength{Entropy: int(bits)}
+	for _, threshold := range scoreThresholds {
+		if bits >= threshold {
+			s.Score++
+		}
+	}
+	return s
+}
+
+// charsetSize returns the number of characters in the classes the password uses.
+func charsetSize(password string) int {
+	var size int
+	classes := classesOf(password)
+	if classes&lowerClass != 0 {
+		size += 26
+	}
+	if classes&upperClass != 0 {
+		size += 26
+	}
+	if classes&digitClass != 0 {
+		size += 10
+	}
+	if classes&symbolClass != 0 {
+		size += 33
+	}
+	if classes&otherClass != 0 {
+		size += 100
+	}
+	return max(size, 2)
+}
+
+// The character classes, as bits so that a password's classes can be combined.
+const (
+	lowerClass = 1 << iota
+	upperClass
+	digitClass
+	symbolClass
+	otherClass
+)
+
+// classesOf returns the character classes used in a password. Letters outside ASCII,
+// such as Arabic ones, are a class of their own.
+func classesOf(password string) int {
+	var classes int
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			classes |= lowerClass
+		case r >= 'A' && r <= 'Z':
+			classes |= upperClass
+		case r >= '0' && r <= '9':
+			classes |= digitClass
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			classes |= symbolClass
+		default:
+			classes |= otherClass
+		}
+	}
+	return classes
+}
+
+// countClasses returns the number of character classes a password uses.
+func countClasses(password string) int {
+	var n int
+	for classes := classesOf(password); classes != 0; classes &= classes - 1 {
+		n++
+	}
+	return n
+}
+
+// personalTokens splits personal information such as a name or email address into the
+// lower-cased words which a password shouldn't contain. Words shorter than three
+// characters are too common in passwords by chance to be worth checking.
+func personalTokens(personal []string) []string {
+	var tokens []string
+	for _, info := range personal {
+		// Only the local part of an email address is personal; the domain usually isn't.
+		if local, _, ok := strings.Cut(info, "@"); ok {
+			info = local
+		}
+		for _, word := range strings.FieldsFunc(strings.ToLower(info), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(word)) >= 3 {
+				tokens = append(tokens, word)
+			}
+		}
+	}
+	return tokens
+}
+
+// isBreached reports whether the password, or its lower-cased form, is in the filter.
+func isBreached(f *Filter, password string) bool {
+	return f.Contains(password) || f.Contains(strings.ToLower(password))
+}
+
+func abs(r rune) rune {
+	if r < 0 {
+		return -r
+	}
+	return r
+}
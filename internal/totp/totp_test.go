@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret from the RFC 6238 test vectors, "12345678901234567890",
+// base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC's 8 digit codes, cut to their last 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("Code at %d = %q, %v; want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	counter := Counter(now)
+
+	if got, ok := Validate(rfcSecret, "081804", now, 1); !ok || got != counter {
+		t.Errorf("Validate(current code) = %d, %v; want %d, true", got, ok, counter)
+	}
+	// The previous period's code is accepted within the skew, and reports its counter.
+	previous, _ := Code(rfcSecret, counter-1)
+	if got, ok := Validate(rfcSecret, previous, now, 1); !ok || got != counter-1 {
+		t.Errorf("Validate(previous code) = %d, %v; want %d, true", got, ok, counter-1)
+	}
+	if _, ok := Validate(rfcSecret, previous, now, 0); ok {
+		t.Error("Validate accepted the previous code without skew")
+	}
+	for _, code := range []string{"", "000000", "81804", "0818040"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("got a secret of %d characters; want 32", len(secret))
+	}
+
+	u, err := url.Parse(URI("sainpr", "alice@example.com", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/sainpr:alice@example.com" {
+		t.Errorf("got URI %s; want otpauth://totp/sainpr:alice@example.com", u)
+	}
+	if q := u.Query(); q.Get("secret") != secret || q.Get("issuer") != "sainpr" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("got query %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("got code %q; want 10 unique characters in two groups", code)
+		}
+		seen[code] = true
+	}
+
+	if !bytes.Equal(HashRecoveryCode("k7qm2-xp4nd"), HashRecoveryCode(" K7QM2XP4ND")) {
+		t.Error("HashRecoveryCode depends on case, spaces or dashes")
+	}
+	if bytes.Equal(HashRecoveryCode("k7qm2-xp4nd"), HashRecoveryCode("k7qm2-xp4ne")) {
+		t.Error("different codes have the same hash")
+	}
+}
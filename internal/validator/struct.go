@@ -0,0 +1,341 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Field is the value a RuleFunc checks.
+type Field struct {
+	// Value is the field's value, with any pointers dereferenced.
+	Value reflect.Value
+	// Param is the text after the "=" in the rule, e.g. "8" for min=8.
+	Param string
+	// Parent is the struct holding the field, for rules which compare fields. It isn't
+	// valid for values checked with Var().
+	Parent reflect.Value
+}
+
+// RuleFunc reports whether a field passes a validation rule.
+type RuleFunc func(f Field) bool
+
+// rules holds the rules which can be used in validate tags, by name.
+var rules = map[string]RuleFunc{
+	"required": required,
+	"email":    email,
+	"min":      minRule,
+	"max":      maxRule,
+	"oneof":    oneOf,
+	"eqfield":  eqField,
+	"nefield":  neField,
+}
+
+// RegisterRule adds a rule which can then be used in validate tags. The message for a
+// failed rule is looked up with the key "validation.<field>.<rule>", falling back to
+// "validation.<rule>", so a new rule needs a message in the i18n catalogs. Rules must be
+// registered before any validation happens, for example from an init() function, as the
+// rules aren't guarded against concurrent access.
+func RegisterRule(name string, fn RuleFunc) {
+	if _, exists := rules[name]; exists || name == "omitempty" || name == "dive" {
+		panic(fmt.Sprintf("validator: rule %q is already defined", name))
+	}
+	rules[name] = fn
+}
+
+// Validatable is implemented by types with checks which tags can't express, usually
+// ones involving several fields. Struct() calls Validate() once the field rules have
+// run, for nested structs as well as the top-level one.
+type Validatable interface {
+	Validate(v *Validator)
+}
+
+// Struct checks the fields of s, a struct or a pointer to one, against the rules in their
+// validate tags, e.g. `validate:"required,email"`. The rules are run in order and stop
+// at the first one which fails. Errors are keyed by the field's JSON name, with nested
+// fields as "parent.child" and slice elements as "items[0]". Nested structs, and slices
+// of them, are validated as well.
+//
+// Besides the registered rules, a tag may contain "omitempty", which skips the rest of
+// the rules when the field has its zero value (such as a nil pointer), and "dive",
+// which applies the rules after it to each element of a slice, array or map.
+func (v *Validator) Struct(s any) {
+	v.validateStruct("", reflect.ValueOf(s))
+}
+
+// Var checks a single value against the rules in tag, recording any error under name.
+func (v *Validator) Var(name string, value any, tag string) {
+	v.validateField(name, name, reflect.ValueOf(value), reflect.Value{}, tag)
+}
+
+func (v *Validator) validateStruct(prefix string, rv reflect.Value) {
+	rv = indirect(rv)
+	if !rv.IsValid() || rv.Kind() != reflect.Struct {
+		return
+	}
+
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("validate")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		// Embedded structs are flattened into their parent, as they are in JSON.
+		name := fieldName(sf)
+		if sf.Anonymous && name == "" {
+			v.validateStruct(prefix, rv.Field(i))
+			continue
+		}
+		v.validateField(join(prefix, name), name, rv.Field(i), rv, tag)
+	}
+
+	// Validate() may have a pointer receiver, so call it on an addressable copy.
+	ptr := reflect.New(t)
+	ptr.Elem().Set(rv)
+	s, ok := ptr.Interface().(Validatable)
+	if !ok {
+		return
+	}
+	if prefix == "" {
+		s.Validate(v)
+		return
+	}
+	nested := New()
+	s.Validate(nested)
+	for field, msg := range nested.Errors {
+		if _, exists := v.Errors[join(prefix, field)]; !exists {
+			v.Errors[join(prefix, field)] = msg
+		}
+	}
+}
+
+// validateField runs the rules in tag against fv, recording the first failure under
+// path. The name is the field's own name, used for the message key.
+func (v *Validator) validateField(path, name string, fv, parent reflect.Value, tag string) {
+	// The rules after "dive" are for the elements rather than the field itself.
+	fieldRules := strings.Split(tag, ",")
+	var diveTag string
+	dive := slices.Index(fieldRules, "dive")
+	if dive >= 0 {
+		diveTag = strings.Join(fieldRules[dive+1:], ",")
+		fieldRules = fieldRules[:dive]
+	}
+
+	for _, rule := range fieldRules {
+		if rule == "" {
+			continue
+		}
+		if rule == "omitempty" {
+			if !fv.IsValid() || fv.IsZero() {
+				return
+			}
+			continue
+		}
+
+		ruleName, param, _ := strings.Cut(rule, "=")
+		fn, ok := rules[ruleName]
+		if !ok {
+			panic(fmt.Sprintf("validator: unknown rule %q for %s", ruleName, path))
+		}
+		// A nil pointer has no value for the rules to check, so only required fails.
+		value := indirect(fv)
+		if !value.IsValid() {
+			if ruleName == "required" {
+				v.AddError(path, messageKey(name, ruleName, reflect.Invalid))
+				return
+			}
+			continue
+		}
+		if !fn(Field{Value: value, Param: param, Parent: parent}) {
+			v.AddError(path, messageKey(name, ruleName, value.Kind()), ruleArgs(ruleName, param, parent)...)
+			return
+		}
+	}
+
+	value := indirect(fv)
+	if !value.IsValid() {
+		return
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		v.validateStruct(path, value)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			if dive >= 0 {
+				v.validateField(elemPath, name, value.Index(i), parent, diveTag)
+			} else {
+				v.validateStruct(elemPath, value.Index(i))
+			}
+		}
+	case reflect.Map:
+		if dive < 0 {
+			return
+		}
+		iter := value.MapRange()
+		for iter.Next() {
+			elemPath := fmt.Sprintf("%s[%v]", path, iter.Key())
+			v.validateField(elemPath, name, iter.Value(), parent, diveTag)
+		}
+	}
+}
+
+// messageKey returns the message key for a failed rule. The min and max rules count
+// characters for strings and elements for slices, arrays and maps, so they have their
+// own messages for those.
+func messageKey(name, rule string, kind reflect.Kind) string {
+	if rule == "min" || rule == "max" {
+		switch kind {
+		case reflect.String:
+			rule += "_length"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			rule += "_items"
+		}
+	}
+	return "validation." + name + "." + rule
+}
+
+// ruleArgs returns the arguments for the message of a failed rule: the rule's parameter,
+// as a number if it is one. The rules comparing fields refer to the other field by its
+// JSON name rather than its Go name.
+func ruleArgs(rule, param string, parent reflect.Value) []any {
+	if param == "" {
+		return nil
+	}
+	if rule == "eqfield" || rule == "nefield" {
+		if sf, ok := parent.Type().FieldByName(param); ok {
+			return []any{fieldName(sf)}
+		}
+	}
+	if n, err := strconv.Atoi(param); err == nil {
+		return []any{n}
+	}
+	return []any{param}
+}
+
+// fieldName returns the name a struct field is reported under: its JSON name, or for
+// fields without one, its Go name in snake case. Embedded structs without a JSON name
+// return "".
+func fieldName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name != "" && name != "-" {
+		return name
+	}
+	if sf.Anonymous && name == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	runes := []rune(sf.Name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// indirect follows pointers and interfaces to the value they refer to, returning the
+// zero Value for a nil one.
+func indirect(rv reflect.Value) reflect.Value {
+	for rv.IsValid() && (rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface) {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+// The built-in rules.
+
+// required fails for zero values, blank strings and empty slices and maps.
+func required(f Field) bool {
+	switch f.Value.Kind() {
+	case reflect.String:
+		return NotBlank(f.Value.String())
+	case reflect.Slice, reflect.Map:
+		return f.Value.Len() > 0
+	}
+	return !f.Value.IsZero()
+}
+
+func email(f Field) bool {
+	return f.Value.Kind() == reflect.String && Matches(f.Value.String(), EmailRX)
+}
+
+func minRule(f Field) bool {
+	return size(f) >= number(f)
+}
+
+func maxRule(f Field) bool {
+	return size(f) <= number(f)
+}
+
+// oneOf checks the value against a space-separated list, e.g. oneof=user admin.
+func oneOf(f Field) bool {
+	return PermittedValue(fmt.Sprint(f.Value.Interface()), strings.Fields(f.Param)...)
+}
+
+// eqField checks that the value equals another field of the same struct, named by its Go
+// name, e.g. eqfield=Password.
+func eqField(f Field) bool {
+	return reflect.DeepEqual(f.Value.Interface(), otherField(f).Interface())
+}
+
+func neField(f Field) bool {
+	return !eqField(f)
+}
+
+func otherField(f Field) reflect.Value {
+	if !f.Parent.IsValid() {
+		panic("validator: eqfield and nefield can only be used on struct fields")
+	}
+	other := f.Parent.FieldByName(f.Param)
+	if !other.IsValid() {
+		panic(fmt.Sprintf("validator: no field %q to compare with", f.Param))
+	}
+	if other = indirect(other); !other.IsValid() {
+		return reflect.Zero(f.Value.Type())
+	}
+	return other
+}
+
+// size returns the number the min and max rules compare: the number of characters in a
+// string, the number of elements in a slice, array or map, or a number's value.
+func size(f Field) float64 {
+	switch f.Value.Kind() {
+	case reflect.String:
+		return float64(len([]rune(f.Value.String())))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(f.Value.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(f.Value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(f.Value.Uint())
+	case reflect.Float32, reflect.Float64:
+		return f.Value.Float()
+	}
+	panic(fmt.Sprintf("validator: min and max can't be used with %s", f.Value.Kind()))
+}
+
+func number(f Field) float64 {
+	n, err := strconv.ParseFloat(f.Param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validator: invalid number %q", f.Param))
+	}
+	return n
+}
@@ -0,0 +1,135 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testSignup struct {
+	Name            string        `json:"name" validate:"required,max=10"`
+	Email           string        `json:"email" validate:"required,email"`
+	Password        string        `json:"password" validate:"required,min=8"`
+	ConfirmPassword string        `json:"confirm_password" validate:"eqfield=Password"`
+	Nickname        *string       `json:"nickname" validate:"omitempty,required"`
+	Role            string        `validate:"oneof=user admin"`
+	Age             int           `json:"age" validate:"min=18"`
+	Address         testAddress   `json:"address"`
+	Previous        []testAddress `json:"previous" validate:"max=2"`
+	Tags            []string      `json:"tags" validate:"dive,required"`
+	internal        string
+}
+
+// Validate checks a rule across fields which the tags can't express.
+func (s *testSignup) Validate(v *Validator) {
+	v.Check(s.Name == "" || !strings.Contains(s.Password, s.Name), "password", "validation.password.contains_name")
+}
+
+func msg(key string, args ...any) Message {
+	return Message{Key: key, Args: args}
+}
+
+func TestStruct(t *testing.T) {
+	blank := " "
+	valid := testSignup{
+		Name:            "Alice",
+		Email:           "alice@example.com",
+		Password:        "pa55word1234",
+		ConfirmPassword: "pa55word1234",
+		Role:            "user",
+		Age:             30,
+		Address:         testAddress{City: "Riyadh"},
+		Tags:            []string{"books"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *testSignup)
+		want   map[string]Message
+	}{
+		{"Valid", func(s *testSignup) {}, map[string]Message{}},
+		{"Missing fields", func(s *testSignup) { s.Name, s.Email = "", "" }, map[string]Message{
+			"name":  msg("validation.name.required"),
+			"email": msg("validation.email.required"),
+		}},
+		{"First failing rule wins", func(s *testSignup) { s.Email = "alice" }, map[string]Message{
+			"email": msg("validation.email.email"),
+		}},
+		{"Lengths and numbers", func(s *testSignup) { s.Name = "Alexandrina Victoria"; s.Age = 12 }, map[string]Message{
+			"name": msg("validation.name.max_length", 10),
+			"age":  msg("validation.age.min", 18),
+		}},
+		{"Cross-field rules", func(s *testSignup) { s.Password = "alice-pa55word"; s.ConfirmPassword = "other" }, map[string]Message{
+			"confirm_password": msg("validation.confirm_password.eqfield", "password"),
+		}},
+		{"Validate method", func(s *testSignup) { s.Password = "Alice12345"; s.ConfirmPassword = s.Password }, map[string]Message{
+			"password": msg("validation.password.contains_name"),
+		}},
+		{"Pointers", func(s *testSignup) { s.Nickname = &blank }, map[string]Message{
+			"nickname": msg("validation.nickname.required"),
+		}},
+		{"Go field names", func(s *testSignup) { s.Role = "owner" }, map[string]Message{
+			"role": msg("validation.role.oneof", "user admin"),
+		}},
+		{"Nested structs and slices", func(s *testSignup) {
+			s.Address.City = ""
+			s.Previous = []testAddress{{City: "Jeddah"}, {}}
+		}, map[string]Message{
+			"address.city":     msg("validation.city.required"),
+			"previous[1].city": msg("validation.city.required"),
+		}},
+		{"Slice length", func(s *testSignup) { s.Previous = make([]testAddress, 3) }, map[string]Message{
+			"previous": msg("validation.previous.max_items", 2),
+		}},
+		{"Dive", func(s *testSignup) { s.Tags = []string{"books", ""} }, map[string]Message{
+			"tags[1]": msg("validation.tags.required"),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.modify(&s)
+			v := New()
+			v.Struct(&s)
+			if !reflect.DeepEqual(v.Errors, tt.want) {
+				t.Errorf("got errors %v; want %v", v.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestVar(t *testing.T) {
+	v := New()
+	v.Var("password", "short", "required,min=8")
+	v.Var("email", "bob@example.com", "required,email")
+	want := map[string]Message{"password": msg("validation.password.min_length", 8)}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got errors %v; want %v", v.Errors, want)
+	}
+}
+
+func TestRegisterRule(t *testing.T) {
+	RegisterRule("lowercase", func(f Field) bool {
+		return f.Value.String() == strings.ToLower(f.Value.String())
+	})
+	t.Cleanup(func() { delete(rules, "lowercase") })
+
+	v := New()
+	v.Var("username", "Alice", "required,lowercase")
+	want := map[string]Message{"username": msg("validation.username.lowercase")}
+	if !reflect.DeepEqual(v.Errors, want) {
+		t.Errorf("got errors %v; want %v", v.Errors, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a rule twice didn't panic")
+		}
+	}()
+	RegisterRule("required", required)
+}
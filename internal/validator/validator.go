@@ -13,14 +13,25 @@ import (
 // note further down the page.
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
-// Define a new Validator type which contains a map of validation errors.
+// Message identifies a validation error by the key of its message in the catalogs of
+// the i18n package, so that it can be sent to each client in their own language.
+type Message struct {
+	Key  string
+	Args []any
+}
+
+// Define a new Validator type which contains a map of validation errors, keyed by the
+// name of the field which failed validation. Details holds any further information
+// about the failures which is sent to the client alongside them, such as the estimated
+// strength of a rejected password.
 type Validator struct {
-	Errors map[string]string
+	Errors  map[string]Message
+	Details map[string]any
 }
 
 // New is a helper which creates a new Validator instance with an empty errors map.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]Message)}
 }
 
 // Valid returns true if the errors map doesn't contain any entries.
@@ -28,18 +39,26 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the map (so long as no entry already exists for
-// the given key).
-func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+// AddError adds the message with the given message key and arguments to the map for a
+// field (so long as no entry already exists for the field).
+func (v *Validator) AddError(field, key string, args ...any) {
+	if _, exists := v.Errors[field]; !exists {
+		v.Errors[field] = Message{Key: key, Args: args}
+	}
+}
+
+// AddDetail records further information about the validation failures under key.
+func (v *Validator) AddDetail(key string, value any) {
+	if v.Details == nil {
+		v.Details = make(map[string]any)
 	}
+	v.Details[key] = value
 }
 
 // Check adds an error message to the map only if a validation check is not 'ok'.
-func (v *Validator) Check(ok bool, key, message string) {
+func (v *Validator) Check(ok bool, field, key string, args ...any) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddError(field, key, args...)
 	}
 }
 
@@ -1 +0,0 @@
-DROP TABLE IF EXISTS admins
\ No newline at end of file
@@ -0,0 +1,26 @@
+// Package migrations embeds the SQL migration files, so that the API binary can apply
+// them itself without the migrate CLI or a copy of this directory.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+// files holds the migrations for each database, in a directory named after it. The
+// file names follow the <version>_<name>.<up|down>.sql convention used by the migrate
+// CLI, and both directories must contain the same versions.
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// For returns the migrations for the named database, "postgres" or "sqlite".
+func For(dialect string) (fs.FS, error) {
+	switch dialect {
+	case "postgres", "sqlite":
+		return fs.Sub(files, dialect)
+	default:
+		return nil, fmt.Errorf("no migrations for database %q", dialect)
+	}
+}
@@ -0,0 +1,2 @@
+ALTER TABLE user_categories DROP COLUMN IF EXISTS granted_by;
+ALTER TABLE user_categories DROP COLUMN IF EXISTS granted_at;
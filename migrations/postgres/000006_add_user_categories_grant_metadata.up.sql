@@ -0,0 +1,2 @@
+ALTER TABLE user_categories ADD COLUMN granted_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE user_categories ADD COLUMN granted_by bigint REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    key text PRIMARY KEY,
+    failures integer NOT NULL,
+    last_failure_at timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    password_hash BLOB NOT NULL,
+    pfp_filepath TEXT DEFAULT 'defaultpfp.jpeg');
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL
+);
+
+-- sample data
+INSERT INTO categories (name) values ('cat1');
+INSERT INTO categories (name) values ('thing');
+INSERT INTO categories (name) values ('something');
\ No newline at end of file
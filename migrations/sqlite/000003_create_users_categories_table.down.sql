@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_categories;
\ No newline at end of file
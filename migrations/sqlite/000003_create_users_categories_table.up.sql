@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS user_categories (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    category_id INTEGER UNIQUE NOT NULL REFERENCES categories(id)
+);
\ No newline at end of file
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS admins (
+    id INTEGER NOT NULL REFERENCES users(id)
+);
\ No newline at end of file
@@ -0,0 +1,7 @@
+CREATE TABLE user_categories_old (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    category_id INTEGER UNIQUE NOT NULL REFERENCES categories(id)
+);
+INSERT INTO user_categories_old (user_id, category_id) SELECT user_id, category_id FROM user_categories;
+DROP TABLE user_categories;
+ALTER TABLE user_categories_old RENAME TO user_categories;
@@ -0,0 +1,10 @@
+-- SQLite can't add a column whose default is CURRENT_TIMESTAMP, so the table is rebuilt.
+CREATE TABLE user_categories_new (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    category_id INTEGER UNIQUE NOT NULL REFERENCES categories(id),
+    granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    granted_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+INSERT INTO user_categories_new (user_id, category_id) SELECT user_id, category_id FROM user_categories;
+DROP TABLE user_categories;
+ALTER TABLE user_categories_new RENAME TO user_categories;